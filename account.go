@@ -1,7 +1,6 @@
 package dao
 
 import (
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -16,21 +15,20 @@ type Account struct {
 	usingChar *Char
 	isOnline  bool
 	sock      *wsConn
-}
-
-type AccountClientCall interface {
-	CreateChar(name string)
-	LoginChar(charSlog int)
-	Logout()
+	// session resume
+	resumeToken string
+	detached    bool
+	detachTimer *WorldTimer
 }
 
 type AccountDumpDB struct {
-	Id       bson.ObjectId `bson:"_id"`
-	Username string        `bson:"username"`
-	Password string        `bson:"password"`
-	Email    string        `bson:"email"`
-	MaxChars int           `bson:"maxChars"`
-	Chars    []*CharDumpDB `bson:"chars"`
+	Id            bson.ObjectId `bson:"_id"`
+	SchemaVersion int           `bson:"schemaVersion"`
+	Username      string        `bson:"username"`
+	Password      string        `bson:"password"`
+	Email         string        `bson:"email"`
+	MaxChars      int           `bson:"maxChars"`
+	Chars         []*CharDumpDB `bson:"chars"`
 }
 
 func (aDump *AccountDumpDB) Load(w *World) *Account {
@@ -57,22 +55,14 @@ func NewAccount(username string, password string) *Account {
 	return a
 }
 
-func (a *Account) AccountClientCall() AccountClientCall {
-	return a
-}
-
-func (a *Account) SaveByOtherDB(db *DaoDB) {
-	accs := db.accounts
-	if _, err := accs.UpsertId(a.bsonId, a.DumpDB()); err != nil {
+func (a *Account) SaveByOtherDB(db Storage) {
+	if err := db.SaveAccount(a.DumpDB()); err != nil {
 		panic(err)
 	}
 }
 
 func (a *Account) Save() {
-	accs := a.world.db.accounts
-	if _, err := accs.UpsertId(a.bsonId, a.DumpDB()); err != nil {
-		panic(err)
-	}
+	a.SaveByOtherDB(a.world.db)
 }
 
 func (a *Account) Username() string {
@@ -85,12 +75,13 @@ func (a *Account) DumpDB() *AccountDumpDB {
 		chars[i] = char.DumpDB()
 	}
 	return &AccountDumpDB{
-		Id:       a.bsonId,
-		Username: a.username,
-		Password: a.password,
-		Email:    a.email,
-		MaxChars: a.maxChars,
-		Chars:    chars,
+		Id:            a.bsonId,
+		SchemaVersion: CurrentSchemaVersion(),
+		Username:      a.username,
+		Password:      a.password,
+		Email:         a.email,
+		MaxChars:      a.maxChars,
+		Chars:         chars,
 	}
 }
 
@@ -149,6 +140,9 @@ func (a *Account) Login(sock *wsConn) {
 	a.isOnline = true
 	a.sock = sock
 	sock.account = a
+	if sock.HasFeature(ProtocolFeatureResume) {
+		a.resumeToken = newResumeToken()
+	}
 }
 
 func (a *Account) UsingChar() *Char {
@@ -168,24 +162,23 @@ func (a *Account) CreateChar(name string) {
 		a.sock.SendClientCall(clientCall)
 		return
 	}
-	queryChar := bson.M{"chars": bson.M{"$elemMatch": bson.M{"name": name}}}
-	err := a.world.db.accounts.Find(queryChar).Select(bson.M{"_id": 1}).One(&struct{}{})
-	if err != nil && err != mgo.ErrNotFound {
+	found, err := a.world.db.HasCharName(name)
+	if err != nil {
 		panic(err)
-	} else if err != mgo.ErrNotFound {
+	} else if found {
 		clientCall := &ClientCall{
 			Receiver: "account",
 			Method:   "handleErrorCreateChar",
 			Params:   []interface{}{"duplicate char name."},
 		}
 		a.sock.SendClientCall(clientCall)
-	} else if err == mgo.ErrNotFound {
+	} else {
 		char := NewChar(name, a)
 		char.slotIndex = len(a.chars)
 		a.chars = append(a.chars, char)
 		char.GetInitItems()
 		dump := char.DumpDB()
-		go char.SaveByDumpDB(dump)
+		char.SaveByDumpDB(dump)
 		// client
 		a.world.logger.Println(
 			"Account:", a.username,
@@ -208,13 +201,17 @@ func (a *Account) Logout() {
 		return
 	}
 	a.isOnline = false
+	a.detached = false
+	a.resumeToken = ""
 	if a.usingChar != nil {
 		c := a.usingChar
 		if c.isOnline == false {
 			return
 		}
 		c.isOnline = false
-		c.Save()
+		dump := c.DumpDB()
+		c.SaveByDumpDB(dump)
+		c.SnapshotByDumpDB(dump, CharSnapshotLogout)
 		if c.scene != nil {
 			c.lastId = c.id
 			c.lastSceneName = c.scene.name
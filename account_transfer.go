@@ -0,0 +1,359 @@
+package dao
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"strconv"
+	"time"
+)
+
+const AccountExportFormat = "dao-account-v1"
+
+// AccountExport is one account as written by "dao export".
+// Account is the stored document, object ids as {"$oid": hex}
+// and times as {"$date": RFC3339}.
+type AccountExport struct {
+	Format        string                 `json:"format"`
+	ExportedAt    time.Time              `json:"exportedAt"`
+	SchemaVersion int                    `json:"schemaVersion"`
+	Account       map[string]interface{} `json:"account"`
+}
+
+func ExportAccount(db Storage, username string, withPassword bool) (*AccountExport, error) {
+	accDump, err := db.FindAccount(username)
+	if err != nil {
+		return nil, err
+	}
+	if !withPassword {
+		accDump.Password = ""
+	}
+	dat, err := bson.Marshal(accDump)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(dat, &doc); err != nil {
+		return nil, err
+	}
+	return &AccountExport{
+		Format:        AccountExportFormat,
+		ExportedAt:    time.Now(),
+		SchemaVersion: accDump.SchemaVersion,
+		Account:       exportValue(doc).(map[string]interface{}),
+	}, nil
+}
+
+func (exp *AccountExport) Write(w io.Writer) error {
+	dat, err := json.MarshalIndent(exp, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(dat, '\n'))
+	return err
+}
+
+func ReadAccountExport(r io.Reader) (*AccountExport, error) {
+	dec := json.NewDecoder(r)
+	// ints stay ints.
+	dec.UseNumber()
+	exp := &AccountExport{}
+	if err := dec.Decode(exp); err != nil {
+		return nil, err
+	}
+	if exp.Format != AccountExportFormat {
+		return nil, errors.New("account export: unknown format " + strconv.Quote(exp.Format))
+	}
+	if exp.Account == nil {
+		return nil, errors.New("account export: missing account")
+	}
+	return exp, nil
+}
+
+func exportValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case bson.M:
+		m := make(map[string]interface{}, len(vv))
+		for key, child := range vv {
+			m[key] = exportValue(child)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(vv))
+		for i, child := range vv {
+			list[i] = exportValue(child)
+		}
+		return list
+	case bson.ObjectId:
+		return map[string]interface{}{"$oid": vv.Hex()}
+	case time.Time:
+		return map[string]interface{}{"$date": vv.Format(time.RFC3339Nano)}
+	}
+	return v
+}
+
+func importValue(v interface{}) (interface{}, error) {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		if len(vv) == 1 {
+			if hex, ok := vv["$oid"].(string); ok {
+				if !bson.IsObjectIdHex(hex) {
+					return nil, errors.New("invalid object id " + strconv.Quote(hex))
+				}
+				return bson.ObjectIdHex(hex), nil
+			}
+			if date, ok := vv["$date"].(string); ok {
+				return time.Parse(time.RFC3339Nano, date)
+			}
+		}
+		doc := make(bson.M, len(vv))
+		for key, child := range vv {
+			value, err := importValue(child)
+			if err != nil {
+				return nil, err
+			}
+			doc[key] = value
+		}
+		return doc, nil
+	case []interface{}:
+		list := make([]interface{}, len(vv))
+		for i, child := range vv {
+			value, err := importValue(child)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+	case json.Number:
+		if n, err := vv.Int64(); err == nil {
+			return n, nil
+		}
+		return vv.Float64()
+	}
+	return v, nil
+}
+
+type ImportAccountOptions struct {
+	// Username to import as, empty keeps exported one.
+	Username string
+	// Replace existing account of Username, its chars are snapshoted first.
+	Replace bool
+	// RemapIds gives chars and items new ids, always done
+	// when imported as another username, so a copy never shares ids.
+	RemapIds bool
+	// CharNameSuffix is appended to every char name.
+	CharNameSuffix string
+	// Password hashed, empty keeps exported one.
+	Password string
+	// SnapshotKeep of replaced chars.
+	SnapshotKeep int
+}
+
+type ImportAccountResult struct {
+	Username string
+	Chars    []string
+	Replaced bool
+	Remapped bool
+}
+
+// ImportAccount migrates and validates exp then saves it,
+// account should not be online.
+func ImportAccount(db Storage, catalog *ItemCatalog, exp *AccountExport, opts *ImportAccountOptions) (*ImportAccountResult, error) {
+	value, err := importValue(exp.Account)
+	if err != nil {
+		return nil, err
+	}
+	accDump, err := DecodeAccountDoc(value.(bson.M))
+	if err != nil {
+		return nil, err
+	}
+	exportedName := accDump.Username
+	if opts.Username != "" {
+		accDump.Username = opts.Username
+	}
+	if accDump.Username == "" {
+		return nil, errors.New("import: missing username")
+	}
+	if opts.Password != "" {
+		accDump.Password = opts.Password
+	}
+	if accDump.Password == "" {
+		return nil, errors.New("import: exported without password, give a new one")
+	}
+	existing, err := db.FindAccount(accDump.Username)
+	if err == ErrStorageNotFound {
+		existing = nil
+	} else if err != nil {
+		return nil, err
+	} else if !opts.Replace {
+		return nil, errors.New("import: account " + accDump.Username + " exists")
+	}
+	result := &ImportAccountResult{
+		Username: accDump.Username,
+		Replaced: existing != nil,
+		Remapped: opts.RemapIds || accDump.Username != exportedName,
+	}
+	if existing != nil {
+		accDump.Id = existing.Id
+	} else {
+		accDump.Id = bson.NewObjectId()
+	}
+	for _, charDump := range accDump.Chars {
+		if charDump == nil {
+			continue
+		}
+		charDump.Name += opts.CharNameSuffix
+		if result.Remapped {
+			charDump.Id = bson.NewObjectId()
+			remapItemInstanceIds(charDump)
+		}
+		result.Chars = append(result.Chars, charDump.Name)
+	}
+	if err := validateImportAccount(db, catalog, accDump, existing); err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		for _, charDump := range existing.Chars {
+			if charDump == nil {
+				continue
+			}
+			snap := NewCharSnapshot(existing.Username, existing.Id, charDump,
+				CharSnapshotBeforeImport)
+			if err := db.SaveCharSnapshot(snap, opts.SnapshotKeep+1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := db.SaveAccount(accDump); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func remapItemInstanceIds(charDump *CharDumpDB) {
+	remap := func(item *ItemDumpDB) {
+		if item != nil {
+			item.InstanceId = bson.NewObjectId()
+		}
+	}
+	for _, eq := range charDump.UsingEquips {
+		if eq != nil {
+			remap(eq.Item)
+		}
+	}
+	if charDump.Items == nil {
+		return
+	}
+	for _, eq := range charDump.Items.Equipment {
+		if eq != nil {
+			remap(eq.Item)
+		}
+	}
+	for _, etc := range charDump.Items.EtcItem {
+		if etc != nil {
+			remap(etc.Item)
+		}
+	}
+	for _, us := range charDump.Items.UseSelfItem {
+		if us != nil {
+			remap(us.Item)
+		}
+	}
+}
+
+func validateImportAccount(db Storage, catalog *ItemCatalog, accDump *AccountDumpDB, existing *AccountDumpDB) error {
+	if len(accDump.Chars) > accDump.MaxChars {
+		return fmt.Errorf("import: %d chars over maxChars %d",
+			len(accDump.Chars), accDump.MaxChars)
+	}
+	ownNames := make(map[string]bool)
+	if existing != nil {
+		for _, charDump := range existing.Chars {
+			if charDump != nil {
+				ownNames[charDump.Name] = true
+			}
+		}
+	}
+	names := make(map[string]bool)
+	for i, charDump := range accDump.Chars {
+		if charDump == nil {
+			continue
+		}
+		if charDump.SlotIndex != i {
+			return fmt.Errorf("import: char %s in slot %d has slotIndex %d",
+				charDump.Name, i, charDump.SlotIndex)
+		}
+		if charDump.Name == "" || names[charDump.Name] {
+			return fmt.Errorf("import: char name %q empty or duplicated", charDump.Name)
+		}
+		names[charDump.Name] = true
+		if !ownNames[charDump.Name] {
+			taken, err := db.HasCharName(charDump.Name)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return errors.New("import: char name " + charDump.Name + " taken")
+			}
+		}
+		if err := validateImportItems(catalog, charDump); err != nil {
+			return fmt.Errorf("import: char %s: %v", charDump.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateImportItems checks items exist and are in slots of their type.
+func validateImportItems(catalog *ItemCatalog, charDump *CharDumpDB) error {
+	check := func(slot string, i int, iType string, item *ItemDumpDB) error {
+		if item == nil {
+			return fmt.Errorf("%s.%d missing item", slot, i)
+		}
+		if !catalog.Has(item.BaseId) {
+			return fmt.Errorf("%s.%d unknown item %d", slot, i, item.BaseId)
+		}
+		if ItemTypeByBaseId(item.BaseId) != iType {
+			return fmt.Errorf("%s.%d item %d is not %s", slot, i, item.BaseId, iType)
+		}
+		return nil
+	}
+	for i, eq := range charDump.UsingEquips {
+		if eq == nil {
+			continue
+		}
+		if err := check("usingEquips", i, "equipment", eq.Item); err != nil {
+			return err
+		}
+	}
+	if charDump.Items == nil {
+		return nil
+	}
+	for i, eq := range charDump.Items.Equipment {
+		if eq == nil {
+			continue
+		}
+		if err := check("items.equipment", i, "equipment", eq.Item); err != nil {
+			return err
+		}
+	}
+	for i, etc := range charDump.Items.EtcItem {
+		if etc == nil {
+			continue
+		}
+		if err := check("items.etcItem", i, "etcItem", etc.Item); err != nil {
+			return err
+		}
+	}
+	for i, us := range charDump.Items.UseSelfItem {
+		if us == nil {
+			continue
+		}
+		if err := check("items.useSelfItem", i, "useSelfItem", us.Item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
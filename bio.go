@@ -575,7 +575,7 @@ func (b *Bio) healSelfByRestUpdate(delta float32) bool {
 				},
 			},
 		}
-		b.clientCallPublisher.PublishClientCall(clientCall)
+		b.PublishLegacyClientCall(clientCall)
 		return true
 	}
 	return false
@@ -622,6 +622,15 @@ func (b *Bio) PublishClientCall(cs ...*ClientCall) {
 	}
 }
 
+// PublishLegacyClientCall is for state view snapshot carries,
+// only chars without view snapshot get it.
+func (b *Bio) PublishLegacyClientCall(c *ClientCall) {
+	if b.scene == nil {
+		return
+	}
+	b.scene.DispatchLegacyClientCall(b.clientCallPublisher, c)
+}
+
 func (b *Bio) Level() int {
 	return b.level
 }
@@ -3,7 +3,6 @@ package dao
 import (
 	"github.com/xuhaojun/chipmunk"
 	"github.com/xuhaojun/chipmunk/vect"
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"math/rand"
 	"reflect"
@@ -37,43 +36,6 @@ func (sid CharSkillBaseId) MaxLevel() int {
 	return -1
 }
 
-type CharClientCall interface {
-	Logout()
-	Move(x, y float32)
-	ShutDownMove()
-	TalkScene(content string)
-	// equip
-	EquipBySlot(slot int)
-	UnequipBySlot(slot int)
-	// use
-	DropItem(baseId int, slotIndex int)
-	PickItem(sbId int)
-	UseItemBySlot(slot int)
-	// npc
-	TalkNpcById(nid int)
-	CancelTalkingNpc()
-	ResponseTalkingNpc(optIndex int)
-	//shop
-	BuyItemFromOpeningShop(sellIndex int)
-	SellItemToOpeningShop(baseId int, slotIndex int)
-	CancelOpeningShop()
-	// skill
-	UseFireBall()
-	UseSkillByBaseId(sid int)
-	// hotkey
-	SetSkillHotKey(index int, sid int)
-	SetLeftSkillHotKey(sid int)
-	SetRightSkillHotKey(sid int)
-	ClearNormalHotKey(index int)
-	ClearSkillHotKey(index int)
-	SetNormalHotKey(index int, itemBaseId int, slotIndex int)
-	// party
-	JoinPartyByCharName(name string)
-	CreateParty(name string) *Party
-	LeaveParty() *Party
-	ClearQuest(qid int)
-}
-
 type Charer interface {
 	Bioer
 	DumpDB() *CharDumpDB
@@ -82,9 +44,9 @@ type Charer interface {
 	OnReceiveClientCall(sender ClientCallPublisher, c *ClientCall)
 	Save()
 	SaveByDumpDB(dump *CharDumpDB)
+	SnapshotByDumpDB(dump *CharDumpDB, reason string)
 	SendClientCall(msg ...*ClientCall)
 	SendClientCalls(msg []*ClientCall)
-	CharClientCall() CharClientCall
 	CharClient() *CharClient
 	CharClientBasic() *CharClientBasic
 	GetItemByBaseId(baseId int)
@@ -98,6 +60,11 @@ type Charer interface {
 	TakeQuest(q *Quest)
 	ClearQuest(qid int)
 	FindQuest(qid int) (*Quest, bool)
+	UpdateViewSnapshot()
+	ResyncViewAOI()
+	ViewSnapshotEnabled() bool
+	LastPortalTime() time.Time
+	SetLastPortalTime(t time.Time)
 }
 
 type Char struct {
@@ -128,6 +95,11 @@ type Char struct {
 	hotKeys       *CharHotKeys
 	//
 	quests map[int]*Quest
+	//
+	viewSnapshot *ViewSnapshotState
+	//
+	lastSnapshotTime time.Time
+	lastPortalTime   time.Time
 }
 
 type CharClient struct {
@@ -151,6 +123,7 @@ type CharClientBasic struct {
 
 type CharDumpDB struct {
 	Id            bson.ObjectId           `bson:"_id"`
+	SchemaVersion int                     `bson:"schemaVersion"`
 	SlotIndex     int                     `bson:"slotIndex"`
 	Name          string                  `bson:"name"`
 	Level         int                     `bson:"level"`
@@ -206,24 +179,25 @@ func NewCharHotKeys() *CharHotKeys {
 
 func (c *Char) DumpDB() *CharDumpDB {
 	cDump := &CharDumpDB{
-		Id:          c.bsonId,
-		SlotIndex:   c.slotIndex,
-		Name:        c.name,
-		Level:       c.level,
-		Hp:          c.hp,
-		Mp:          c.mp,
-		Str:         c.str,
-		Vit:         c.vit,
-		Wis:         c.wis,
-		Spi:         c.spi,
-		Dzeny:       c.dzeny,
-		Items:       c.items.DumpDB(),
-		UsingEquips: c.usingEquips.DumpDB(),
-		LastScene:   nil,
-		SaveScene:   c.saveSceneInfo,
-		BodyViewId:  c.bodyViewId,
-		BodyShape:   &CircleShape{32},
-		HotKeys:     c.hotKeys,
+		Id:            c.bsonId,
+		SchemaVersion: CurrentSchemaVersion(),
+		SlotIndex:     c.slotIndex,
+		Name:          c.name,
+		Level:         c.level,
+		Hp:            c.hp,
+		Mp:            c.mp,
+		Str:           c.str,
+		Vit:           c.vit,
+		Wis:           c.wis,
+		Spi:           c.spi,
+		Dzeny:         c.dzeny,
+		Items:         c.items.DumpDB(),
+		UsingEquips:   c.usingEquips.DumpDB(),
+		LastScene:     nil,
+		SaveScene:     c.saveSceneInfo,
+		BodyViewId:    c.bodyViewId,
+		BodyShape:     &CircleShape{32},
+		HotKeys:       c.hotKeys,
 	}
 	cDump.LearnedSkills = map[string]int{}
 	for id, level := range c.learnedSkills {
@@ -446,6 +420,7 @@ func NewChar(name string, acc *Account) *Char {
 		hotKeys:       NewCharHotKeys(),
 		pickRadius:    111.0,
 		quests:        make(map[int]*Quest, 0),
+		viewSnapshot:  NewViewSnapshotState(),
 	}
 	for _, shape := range c.body.Shapes {
 		shape.Layer = shape.Layer | CharLayer
@@ -535,6 +510,14 @@ func (c *Char) FindQuest(qid int) (*Quest, bool) {
 	return quest, ok
 }
 
+func (c *Char) LastPortalTime() time.Time {
+	return c.lastPortalTime
+}
+
+func (c *Char) SetLastPortalTime(t time.Time) {
+	c.lastPortalTime = t
+}
+
 // TODO
 // should check some quest can't give up.
 func (c *Char) ClearQuest(qid int) {
@@ -715,25 +698,13 @@ func (c *Char) CharClientBasic() *CharClientBasic {
 	}
 }
 
-func (c *Char) CharClientCall() CharClientCall {
-	return c
-}
-
-func (c *Char) saveChar(accs *mgo.Collection, dump *CharDumpDB) {
-	ci := strconv.Itoa(c.slotIndex)
-	cii := "chars." + ci
-	update := bson.M{"$set": bson.M{cii: dump}}
-	if err := accs.UpdateId(c.account.bsonId, update); err != nil {
-		panic(err)
-	}
-}
-
 func (c *Char) SaveByDumpDB(dump *CharDumpDB) {
-	c.saveChar(c.account.world.db.CloneSession().accounts, dump)
+	acc := c.account
+	acc.world.persist.SaveChar(acc.username, acc.bsonId, c.slotIndex, dump)
 }
 
 func (c *Char) Save() {
-	c.saveChar(c.account.world.db.accounts, c.DumpDB())
+	c.SaveByDumpDB(c.DumpDB())
 }
 
 func (c *Char) PickItem(sbId int) {
@@ -752,10 +723,13 @@ func (c *Char) PickItem(sbId int) {
 		return
 	}
 	scene.Remove(item)
+	picked, count := item, ItemCount(item)
 	item, slotIndex := c.GetItem(item)
 	if slotIndex == -1 {
 		return
 	}
+	// item may be the stack picked one merged into.
+	c.recordItem(LedgerSourcePickItem, picked, count, "")
 	itemsUpdate := make(map[string]interface{}, 1)
 	itemsUpdate[strconv.Itoa(slotIndex)] = item.Client()
 	itemsClientUpdate := map[string]interface{}{
@@ -815,10 +789,12 @@ func (c *Char) DropItem(id int, slotIndex int) {
 	if id <= 0 || slotIndex < 0 {
 		return
 	}
+	c.SnapshotBeforeRisky(CharSnapshotDropItem)
 	item := c.items.RemoveItem(id, slotIndex)
 	if item == nil || reflect.ValueOf(item).IsNil() {
 		return
 	}
+	c.recordItem(LedgerSourceDropItem, item, -ItemCount(item), "")
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	body := item.Body()
 	pos := c.body.Position()
@@ -870,8 +846,10 @@ func (c *Char) Login() {
 	if foundLast == false {
 		saveScene, foundSave := c.world.scenes[c.saveSceneInfo.Name]
 		if foundSave == false {
-			c.saveSceneInfo = &SceneInfo{"daoCity", 0, 0}
-			scene = c.world.scenes["daoCity"]
+			scene = c.world.scenes[c.world.configs.CharConfigs.FirstScene.Name]
+			sp := scene.DefaultSpawnPoint()
+			c.saveSceneInfo = &SceneInfo{scene.name, sp.X, sp.Y}
+			c.SetPosition(sp.X, sp.Y)
 		} else {
 			scene = saveScene
 		}
@@ -884,7 +862,9 @@ func (c *Char) Login() {
 }
 
 func (c *Char) onKillMob(m Mober) {
-	c.dzeny += m.Level() * 100
+	gain := m.Level() * 100
+	c.dzeny += gain
+	c.recordZeny(LedgerSourceKillMob, gain, m.Name())
 	clientCall1 := &ClientCall{
 		Receiver: "char",
 		Method:   "handleUpdateConfig",
@@ -1006,6 +986,13 @@ func (c *Char) OnSceneObjectEnterViewAOIFunc() func(SceneObjecter) {
 				Params:   []interface{}{enter.NpcClientBasic()},
 			}
 			c.sock.SendClientCall(clientCall)
+		case *Portal:
+			clientCall := &ClientCall{
+				Receiver: "scene",
+				Method:   "handleAddPortal",
+				Params:   []interface{}{enter.Client()},
+			}
+			c.sock.SendClientCall(clientCall)
 		case Charer:
 			if enter != c.Charer() {
 				clientCall := &ClientCall{
@@ -1081,6 +1068,42 @@ func (c *Char) SendClientCalls(msg []*ClientCall) {
 	c.sock.SendClientCalls(msg)
 }
 
+// ViewSnapshotEnabled is false for clients not negotiated it,
+// they still need legacy update calls.
+func (c *Char) ViewSnapshotEnabled() bool {
+	return c.sock != nil && c.sock.HasFeature(ProtocolFeatureViewSnapshot)
+}
+
+func (c *Char) UpdateViewSnapshot() {
+	if c.scene == nil || !c.ViewSnapshotEnabled() {
+		return
+	}
+	sbs := c.viewAOIState.inAreaSceneObjecters
+	entities := make(map[int]*ViewSnapshotEntity, len(sbs)+1)
+	entities[c.id] = NewViewSnapshotEntity(c)
+	for sb, _ := range sbs {
+		b, isBioer := sb.(Bioer)
+		if !isBioer || sb.Scene() != c.scene {
+			continue
+		}
+		entities[sb.Id()] = NewViewSnapshotEntity(b)
+	}
+	delta := c.viewSnapshot.Next(c.scene.name, entities)
+	if delta == nil {
+		return
+	}
+	clientCall := &ClientCall{
+		Receiver: "char",
+		Method:   "handleViewSnapshot",
+		Params:   []interface{}{delta},
+	}
+	c.SendClientCall(clientCall)
+}
+
+func (c *Char) AckViewSnapshot(seq int) {
+	c.viewSnapshot.Ack(seq)
+}
+
 func (c *Char) Bioer() Bioer {
 	return c
 }
@@ -1421,6 +1444,7 @@ func (c *Char) GetItemByBaseId(baseId int) {
 	if putedSlot == -1 {
 		return
 	}
+	c.recordItem(LedgerSourceGetItem, baseItem, 1, "")
 	itemsUpdate := make(map[string]interface{})
 	iType := item.ItemTypeByBaseId()
 	itemsUpdate[strconv.Itoa(putedSlot)] = item.Client()
@@ -1496,15 +1520,18 @@ func (c *Char) BuyItemFromOpeningShop(i int) {
 		return
 	}
 	baseItem := shop.NewItemBySellIndex(i)
-	if c.dzeny < baseItem.BuyPrice() || baseItem == nil {
+	if baseItem == nil || c.dzeny < baseItem.BuyPrice() {
 		return
 	}
+	c.SnapshotBeforeRisky(CharSnapshotBuyItem)
 	c.dzeny -= baseItem.BuyPrice()
+	c.recordZeny(LedgerSourceBuyShop, -baseItem.BuyPrice(), shop.name)
 	item, putedSlot := c.GetItem(baseItem)
 	if item == nil {
 		c.SendChatMessage("System", "", "You can't get item anymore!")
 		return
 	}
+	c.recordItem(LedgerSourceBuyShop, baseItem, 1, shop.name)
 	// client update
 	itemsUpdate := make(map[string]interface{})
 	iType := item.ItemTypeByBaseId()
@@ -1550,7 +1577,10 @@ func (c *Char) SellItemToOpeningShop(baseId int, slotIndex int) {
 		return
 	}
 	logger.Println("foundItem: ", foundItem)
+	c.SnapshotBeforeRisky(CharSnapshotSellItem)
 	c.dzeny += foundItem.SellPrice()
+	c.recordZeny(LedgerSourceSellShop, foundItem.SellPrice(), c.openingShop.name)
+	c.recordItem(LedgerSourceSellShop, foundItem, -1, c.openingShop.name)
 	var finalItem Itemer
 	switch iType {
 	case "equipment":
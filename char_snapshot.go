@@ -0,0 +1,271 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CharSnapshotLogout        = "logout"
+	CharSnapshotAutoSave      = "autoSave"
+	CharSnapshotSellItem      = "sellItem"
+	CharSnapshotBuyItem       = "buyItem"
+	CharSnapshotDropItem      = "dropItem"
+	CharSnapshotBeforeRestore = "beforeRestore"
+	CharSnapshotBeforeImport  = "beforeImport"
+)
+
+// CharSnapshot is a point-in-time copy of a char, kept rotating per char.
+type CharSnapshot struct {
+	Id        bson.ObjectId `bson:"_id"`
+	CharId    bson.ObjectId `bson:"charId"`
+	AccountId bson.ObjectId `bson:"accountId"`
+	Username  string        `bson:"username"`
+	CharName  string        `bson:"charName"`
+	SlotIndex int           `bson:"slotIndex"`
+	Reason    string        `bson:"reason"`
+	CreatedAt time.Time     `bson:"createdAt"`
+	Char      *CharDumpDB   `bson:"char"`
+}
+
+type CharSnapshotInfo struct {
+	Id        string    `json:"id"`
+	CharName  string    `json:"charName"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+	Level     int       `json:"level"`
+	Dzeny     int       `json:"dzeny"`
+}
+
+func NewCharSnapshot(username string, accountId bson.ObjectId, dump *CharDumpDB, reason string) *CharSnapshot {
+	return &CharSnapshot{
+		Id:        bson.NewObjectId(),
+		CharId:    dump.Id,
+		AccountId: accountId,
+		Username:  username,
+		CharName:  dump.Name,
+		SlotIndex: dump.SlotIndex,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+		Char:      dump,
+	}
+}
+
+func (snap *CharSnapshot) Info() *CharSnapshotInfo {
+	return &CharSnapshotInfo{
+		Id:        snap.Id.Hex(),
+		CharName:  snap.CharName,
+		Reason:    snap.Reason,
+		CreatedAt: snap.CreatedAt,
+		Level:     snap.Char.Level,
+		Dzeny:     snap.Char.Dzeny,
+	}
+}
+
+// DecodeCharSnapshotDoc migrates char of doc then decodes it.
+func DecodeCharSnapshotDoc(doc bson.M) (*CharSnapshot, error) {
+	if charDoc, ok := doc["char"].(bson.M); ok {
+		if _, err := migrateDoc(charDoc, true); err != nil {
+			return nil, err
+		}
+	}
+	dat, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	snap := &CharSnapshot{}
+	if err := bson.Unmarshal(dat, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func (c *Char) SnapshotByDumpDB(dump *CharDumpDB, reason string) {
+	configs := c.world.configs.StorageConfigs.Snapshot
+	if !configs.Enable {
+		return
+	}
+	acc := c.account
+	snap := NewCharSnapshot(acc.username, acc.bsonId, dump, reason)
+	c.world.persist.SaveCharSnapshot(acc.username, acc.bsonId, snap, configs.Keep)
+	c.lastSnapshotTime = snap.CreatedAt
+}
+
+// SnapshotBeforeRisky snapshots char before operation may lose things,
+// at most once per riskyIntervalSeconds.
+func (c *Char) SnapshotBeforeRisky(reason string) {
+	configs := c.world.configs.StorageConfigs.Snapshot
+	interval := time.Duration(configs.RiskyIntervalSeconds) * time.Second
+	if time.Since(c.lastSnapshotTime) < interval {
+		return
+	}
+	c.SnapshotByDumpDB(c.DumpDB(), reason)
+}
+
+// admin, call them from REPL.
+
+func (w *World) findCharId(username string, charName string) (bson.ObjectId, error) {
+	accDump, err := w.findAccount(username)
+	if err != nil {
+		return "", err
+	}
+	for _, charDump := range accDump.Chars {
+		if charDump != nil && charDump.Name == charName {
+			return charDump.Id, nil
+		}
+	}
+	return "", ErrStorageNotFound
+}
+
+// CharSnapshots lists snapshots of char, newest first.
+func (w *World) CharSnapshots(username string, charName string) []*CharSnapshotInfo {
+	charId, err := w.findCharId(username, charName)
+	if err != nil {
+		w.logger.Println("CharSnapshots:", err)
+		return nil
+	}
+	snaps, err := w.db.FindCharSnapshots(charId)
+	if err != nil {
+		w.logger.Println("CharSnapshots:", err)
+		return nil
+	}
+	infos := make([]*CharSnapshotInfo, len(snaps))
+	for i, snap := range snaps {
+		infos[i] = snap.Info()
+	}
+	return infos
+}
+
+func (w *World) findCharSnapshot(id string) (*CharSnapshot, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, errors.New("invalid snapshot id " + id)
+	}
+	return w.db.FindCharSnapshot(bson.ObjectIdHex(id))
+}
+
+// RestoreCharSnapshot kicks owner of char if online and
+// writes snapshot back, current one snapshoted before.
+func (w *World) RestoreCharSnapshot(id string) error {
+	snap, err := w.findCharSnapshot(id)
+	if err != nil {
+		return err
+	}
+	w.KickAccountByUsername(snap.Username)
+	w.persist.Wait(snap.Username)
+	accDump, err := w.db.FindAccount(snap.Username)
+	if err != nil {
+		return err
+	}
+	var current *CharDumpDB
+	for _, charDump := range accDump.Chars {
+		if charDump != nil && charDump.Id == snap.CharId {
+			current = charDump
+		}
+	}
+	if current == nil {
+		return errors.New("char of snapshot " + id + " not found")
+	}
+	keep := w.configs.StorageConfigs.Snapshot.Keep
+	before := NewCharSnapshot(accDump.Username, accDump.Id, current,
+		CharSnapshotBeforeRestore)
+	// keep one more, restoring one should not be rotated out.
+	if err := w.db.SaveCharSnapshot(before, keep+1); err != nil {
+		return err
+	}
+	dump := snap.Char
+	dump.SlotIndex = current.SlotIndex
+	dump.SchemaVersion = CurrentSchemaVersion()
+	if err := w.db.SaveChar(accDump.Id, current.SlotIndex, dump); err != nil {
+		return err
+	}
+	w.logger.Println("Char:", snap.CharName, "restored to snapshot",
+		id, "of", snap.CreatedAt.Format(time.RFC3339)+".")
+	return nil
+}
+
+// DiffCharSnapshots returns changed fields from snapshot a to b.
+func (w *World) DiffCharSnapshots(a string, b string) []string {
+	snapA, err := w.findCharSnapshot(a)
+	if err != nil {
+		w.logger.Println("DiffCharSnapshots:", err)
+		return nil
+	}
+	snapB, err := w.findCharSnapshot(b)
+	if err != nil {
+		w.logger.Println("DiffCharSnapshots:", err)
+		return nil
+	}
+	diffs, err := DiffCharDumpDB(snapA.Char, snapB.Char)
+	if err != nil {
+		w.logger.Println("DiffCharSnapshots:", err)
+		return nil
+	}
+	return diffs
+}
+
+func DiffCharDumpDB(a *CharDumpDB, b *CharDumpDB) ([]string, error) {
+	flatA, err := flattenBson(a)
+	if err != nil {
+		return nil, err
+	}
+	flatB, err := flattenBson(b)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []string{}
+	for path, va := range flatA {
+		vb, ok := flatB[path]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: %v -> (none)", path, va))
+		} else if !reflect.DeepEqual(va, vb) {
+			diffs = append(diffs, fmt.Sprintf("%s: %v -> %v", path, va, vb))
+		}
+	}
+	for path, vb := range flatB {
+		if _, ok := flatA[path]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: (none) -> %v", path, vb))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs, nil
+}
+
+func flattenBson(v interface{}) (map[string]interface{}, error) {
+	dat, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(dat, &doc); err != nil {
+		return nil, err
+	}
+	flat := make(map[string]interface{})
+	flattenValue(flat, "", doc)
+	return flat, nil
+}
+
+func flattenValue(flat map[string]interface{}, prefix string, v interface{}) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return strings.Join([]string{prefix, key}, ".")
+	}
+	switch vv := v.(type) {
+	case bson.M:
+		for key, child := range vv {
+			flattenValue(flat, join(key), child)
+		}
+	case []interface{}:
+		for i, child := range vv {
+			flattenValue(flat, join(fmt.Sprint(i)), child)
+		}
+	default:
+		flat[prefix] = v
+	}
+}
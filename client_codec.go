@@ -0,0 +1,170 @@
+package dao
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+	"reflect"
+)
+
+// ClientCallCodec encodes the client calls of one connection.
+// It is chosen by the websocket subprotocol at handshake time.
+type ClientCallCodec interface {
+	Name() string
+	MessageType() int
+	Marshal(calls []*ClientCall) ([]byte, error)
+	Unmarshal(data []byte, c *ClientCall) error
+	// client side, one call per frame to server
+	// and a batch of calls from server.
+	MarshalCall(c *ClientCall) ([]byte, error)
+	UnmarshalCalls(data []byte) ([]*ClientCall, error)
+}
+
+const (
+	JSONClientCallCodecName    = "dao.json"
+	MsgpackClientCallCodecName = "dao.msgpack"
+)
+
+var clientCallCodecs = map[string]ClientCallCodec{
+	JSONClientCallCodecName:    &JSONClientCallCodec{},
+	MsgpackClientCallCodecName: NewMsgpackClientCallCodec(),
+}
+
+// first is prefered when client offers more than one.
+var ClientCallCodecNames = []string{
+	MsgpackClientCallCodecName,
+	JSONClientCallCodecName,
+}
+
+// json is used when client not send any subprotocol,
+// it is what old clients do.
+func ClientCallCodecByName(name string) ClientCallCodec {
+	c, ok := clientCallCodecs[name]
+	if !ok {
+		return clientCallCodecs[JSONClientCallCodecName]
+	}
+	return c
+}
+
+type JSONClientCallCodec struct{}
+
+func (jc *JSONClientCallCodec) Name() string {
+	return JSONClientCallCodecName
+}
+
+func (jc *JSONClientCallCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jc *JSONClientCallCodec) Marshal(calls []*ClientCall) ([]byte, error) {
+	return json.Marshal(calls)
+}
+
+func (jc *JSONClientCallCodec) Unmarshal(data []byte, c *ClientCall) error {
+	return json.Unmarshal(data, c)
+}
+
+func (jc *JSONClientCallCodec) MarshalCall(c *ClientCall) ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func (jc *JSONClientCallCodec) UnmarshalCalls(data []byte) ([]*ClientCall, error) {
+	calls := make([]*ClientCall, 0)
+	err := json.Unmarshal(data, &calls)
+	return calls, err
+}
+
+type MsgpackClientCallCodec struct {
+	handle *codec.MsgpackHandle
+}
+
+func NewMsgpackClientCallCodec() *MsgpackClientCallCodec {
+	h := &codec.MsgpackHandle{}
+	h.RawToString = true
+	h.WriteExt = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	// reuse json tags, so payload keys same as json.
+	h.TypeInfos = codec.NewTypeInfos([]string{"json"})
+	return &MsgpackClientCallCodec{h}
+}
+
+func (mc *MsgpackClientCallCodec) Name() string {
+	return MsgpackClientCallCodecName
+}
+
+func (mc *MsgpackClientCallCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (mc *MsgpackClientCallCodec) Marshal(calls []*ClientCall) ([]byte, error) {
+	var b []byte
+	err := codec.NewEncoderBytes(&b, mc.handle).Encode(calls)
+	return b, err
+}
+
+func (mc *MsgpackClientCallCodec) Unmarshal(data []byte, c *ClientCall) error {
+	err := codec.NewDecoderBytes(data, mc.handle).Decode(c)
+	if err != nil {
+		return err
+	}
+	for i, param := range c.Params {
+		c.Params[i] = normalizeMsgpackParam(param)
+	}
+	return nil
+}
+
+func (mc *MsgpackClientCallCodec) MarshalCall(c *ClientCall) ([]byte, error) {
+	var b []byte
+	err := codec.NewEncoderBytes(&b, mc.handle).Encode(c)
+	return b, err
+}
+
+func (mc *MsgpackClientCallCodec) UnmarshalCalls(data []byte) ([]*ClientCall, error) {
+	calls := make([]*ClientCall, 0)
+	err := codec.NewDecoderBytes(data, mc.handle).Decode(&calls)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range calls {
+		for i, param := range c.Params {
+			c.Params[i] = normalizeMsgpackParam(param)
+		}
+	}
+	return calls, nil
+}
+
+// handlers expect params look like decoded by encoding/json,
+// number is float64 and object is map[string]interface{}.
+func normalizeMsgpackParam(param interface{}) interface{} {
+	switch v := param.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case []byte:
+		return string(v)
+	case []interface{}:
+		for i, e := range v {
+			v[i] = normalizeMsgpackParam(e)
+		}
+		return v
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = normalizeMsgpackParam(e)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			ks, ok := normalizeMsgpackParam(k).(string)
+			if !ok {
+				continue
+			}
+			m[ks] = normalizeMsgpackParam(e)
+		}
+		return m
+	}
+	return param
+}
@@ -0,0 +1,292 @@
+package dao
+
+import (
+	"errors"
+)
+
+type UsernamePasswordParams struct {
+	Username string `param:"username"`
+	Password string `param:"password"`
+}
+
+type RegisterAccountParams struct {
+	Username string `param:"username"`
+	Password string `param:"password"`
+	Email    string `param:"email,optional"`
+}
+
+type UsernameTokenParams struct {
+	Username string `param:"username"`
+	Token    string `param:"token"`
+}
+
+type NameParams struct {
+	Name string `param:"name"`
+}
+
+func (p *NameParams) Validate() error {
+	if p.Name == "" {
+		return errors.New("empty name")
+	}
+	return nil
+}
+
+type CharSlotParams struct {
+	CharSlot int `param:"charSlot"`
+}
+
+type MoveParams struct {
+	X float32 `param:"x"`
+	Y float32 `param:"y"`
+}
+
+type ContentParams struct {
+	Content string `param:"content"`
+}
+
+type SlotParams struct {
+	Slot int `param:"slot"`
+}
+
+type ItemSlotParams struct {
+	BaseId    int `param:"baseId"`
+	SlotIndex int `param:"slotIndex"`
+}
+
+type SceneObjectIdParams struct {
+	SbId int `param:"sbId"`
+}
+
+type NpcIdParams struct {
+	Nid int `param:"nid"`
+}
+
+type OptIndexParams struct {
+	OptIndex int `param:"optIndex"`
+}
+
+type SellIndexParams struct {
+	SellIndex int `param:"sellIndex"`
+}
+
+type SkillIdParams struct {
+	Sid int `param:"sid"`
+}
+
+type IndexParams struct {
+	Index int `param:"index"`
+}
+
+type SkillHotKeyParams struct {
+	Index int `param:"index"`
+	Sid   int `param:"sid"`
+}
+
+type NormalHotKeyParams struct {
+	Index      int `param:"index"`
+	ItemBaseId int `param:"itemBaseId"`
+	SlotIndex  int `param:"slotIndex"`
+}
+
+type QuestIdParams struct {
+	Qid int `param:"qid"`
+}
+
+type SeqParams struct {
+	Seq int `param:"seq"`
+}
+
+type HelloParams struct {
+	Version  string   `param:"version"`
+	Features []string `param:"features,optional"`
+}
+
+func (p *HelloParams) Validate() error {
+	if p.Version == "" {
+		return errors.New("empty version")
+	}
+	return nil
+}
+
+type PingParams struct {
+	T float64 `param:"t"`
+}
+
+func NewDefaultClientCallRegistry() *ClientCallRegistry {
+	r := NewClientCallRegistry()
+	registerWorldClientCalls(r)
+	registerAccountClientCalls(r)
+	registerCharClientCalls(r)
+	return r
+}
+
+func registerWorldClientCalls(r *ClientCallRegistry) {
+	// must be first call of a conn.
+	r.Register("World", "Hello", RequireNone,
+		func(ctx *ClientCallContext, p *HelloParams) {
+			ctx.World.Hello(p.Version, p.Features, ctx.Conn)
+		})
+	r.Register("World", "RegisterAccount", RequireNoAccount,
+		func(ctx *ClientCallContext, p *RegisterAccountParams) {
+			ctx.World.RegisterAccount(p.Username, p.Password, p.Email, ctx.Conn)
+		})
+	r.Register("World", "LoginAccount", RequireNoAccount,
+		func(ctx *ClientCallContext, p *UsernamePasswordParams) {
+			ctx.World.LoginAccount(p.Username, p.Password, ctx.Conn)
+		})
+	r.Register("World", "LoginAccountBySessionToken", RequireNoAccount,
+		func(ctx *ClientCallContext, p *UsernameTokenParams) {
+			ctx.World.LoginAccountBySessionToken(p.Username, p.Token, ctx.Conn)
+		})
+	r.Register("World", "ResumeSession", RequireNoAccount,
+		func(ctx *ClientCallContext, p *UsernameTokenParams) {
+			ctx.World.ResumeSession(p.Username, p.Token, ctx.Conn)
+		})
+	// echo t back, for measure latency include world loop.
+	r.Register("World", "Ping", RequireNone,
+		func(ctx *ClientCallContext, p *PingParams) {
+			ctx.Conn.SendClientCall(&ClientCall{
+				Receiver: "world",
+				Method:   "handlePong",
+				Params:   []interface{}{p.T},
+			})
+		})
+}
+
+func registerAccountClientCalls(r *ClientCallRegistry) {
+	r.Register("Account", "CreateChar", RequireAccount,
+		func(ctx *ClientCallContext, p *NameParams) {
+			ctx.Account.CreateChar(p.Name)
+		})
+	r.Register("Account", "LoginChar", RequireAccount,
+		func(ctx *ClientCallContext, p *CharSlotParams) {
+			ctx.Account.LoginChar(p.CharSlot)
+		})
+	r.Register("Account", "Logout", RequireAccount,
+		func(ctx *ClientCallContext) {
+			ctx.Account.Logout()
+		})
+}
+
+func registerCharClientCalls(r *ClientCallRegistry) {
+	r.Register("Char", "Logout", RequireChar,
+		func(ctx *ClientCallContext) {
+			ctx.Char.Logout()
+		})
+	r.Register("Char", "Move", RequireChar,
+		func(ctx *ClientCallContext, p *MoveParams) {
+			ctx.Char.Move(p.X, p.Y)
+		})
+	r.Register("Char", "ShutDownMove", RequireChar,
+		func(ctx *ClientCallContext) {
+			ctx.Char.ShutDownMove()
+		})
+	r.Register("Char", "TalkScene", RequireChar,
+		func(ctx *ClientCallContext, p *ContentParams) {
+			ctx.Char.TalkScene(p.Content)
+		})
+	// equip
+	r.Register("Char", "EquipBySlot", RequireChar,
+		func(ctx *ClientCallContext, p *SlotParams) {
+			ctx.Char.EquipBySlot(p.Slot)
+		})
+	r.Register("Char", "UnequipBySlot", RequireChar,
+		func(ctx *ClientCallContext, p *SlotParams) {
+			ctx.Char.UnequipBySlot(p.Slot)
+		})
+	// use
+	r.Register("Char", "DropItem", RequireChar,
+		func(ctx *ClientCallContext, p *ItemSlotParams) {
+			ctx.Char.DropItem(p.BaseId, p.SlotIndex)
+		})
+	r.Register("Char", "PickItem", RequireChar,
+		func(ctx *ClientCallContext, p *SceneObjectIdParams) {
+			ctx.Char.PickItem(p.SbId)
+		})
+	r.Register("Char", "UseItemBySlot", RequireChar,
+		func(ctx *ClientCallContext, p *SlotParams) {
+			ctx.Char.UseItemBySlot(p.Slot)
+		})
+	// npc
+	r.Register("Char", "TalkNpcById", RequireChar,
+		func(ctx *ClientCallContext, p *NpcIdParams) {
+			ctx.Char.TalkNpcById(p.Nid)
+		})
+	r.Register("Char", "CancelTalkingNpc", RequireChar,
+		func(ctx *ClientCallContext) {
+			ctx.Char.CancelTalkingNpc()
+		})
+	r.Register("Char", "ResponseTalkingNpc", RequireChar,
+		func(ctx *ClientCallContext, p *OptIndexParams) {
+			ctx.Char.ResponseTalkingNpc(p.OptIndex)
+		})
+	// shop
+	r.Register("Char", "BuyItemFromOpeningShop", RequireChar,
+		func(ctx *ClientCallContext, p *SellIndexParams) {
+			ctx.Char.BuyItemFromOpeningShop(p.SellIndex)
+		})
+	r.Register("Char", "SellItemToOpeningShop", RequireChar,
+		func(ctx *ClientCallContext, p *ItemSlotParams) {
+			ctx.Char.SellItemToOpeningShop(p.BaseId, p.SlotIndex)
+		})
+	r.Register("Char", "CancelOpeningShop", RequireChar,
+		func(ctx *ClientCallContext) {
+			ctx.Char.CancelOpeningShop()
+		})
+	// skill
+	r.Register("Char", "UseFireBall", RequireChar,
+		func(ctx *ClientCallContext) {
+			ctx.Char.UseFireBall()
+		})
+	r.Register("Char", "UseSkillByBaseId", RequireChar,
+		func(ctx *ClientCallContext, p *SkillIdParams) {
+			ctx.Char.UseSkillByBaseId(p.Sid)
+		})
+	// hotkey
+	r.Register("Char", "SetSkillHotKey", RequireChar,
+		func(ctx *ClientCallContext, p *SkillHotKeyParams) {
+			ctx.Char.SetSkillHotKey(p.Index, p.Sid)
+		})
+	r.Register("Char", "SetLeftSkillHotKey", RequireChar,
+		func(ctx *ClientCallContext, p *SkillIdParams) {
+			ctx.Char.SetLeftSkillHotKey(p.Sid)
+		})
+	r.Register("Char", "SetRightSkillHotKey", RequireChar,
+		func(ctx *ClientCallContext, p *SkillIdParams) {
+			ctx.Char.SetRightSkillHotKey(p.Sid)
+		})
+	r.Register("Char", "ClearNormalHotKey", RequireChar,
+		func(ctx *ClientCallContext, p *IndexParams) {
+			ctx.Char.ClearNormalHotKey(p.Index)
+		})
+	r.Register("Char", "ClearSkillHotKey", RequireChar,
+		func(ctx *ClientCallContext, p *IndexParams) {
+			ctx.Char.ClearSkillHotKey(p.Index)
+		})
+	r.Register("Char", "SetNormalHotKey", RequireChar,
+		func(ctx *ClientCallContext, p *NormalHotKeyParams) {
+			ctx.Char.SetNormalHotKey(p.Index, p.ItemBaseId, p.SlotIndex)
+		})
+	// party
+	r.Register("Char", "JoinPartyByCharName", RequireChar,
+		func(ctx *ClientCallContext, p *NameParams) {
+			ctx.Char.JoinPartyByCharName(p.Name)
+		})
+	r.Register("Char", "CreateParty", RequireChar,
+		func(ctx *ClientCallContext, p *NameParams) {
+			ctx.Char.CreateParty(p.Name)
+		})
+	r.Register("Char", "LeaveParty", RequireChar,
+		func(ctx *ClientCallContext) {
+			ctx.Char.LeaveParty()
+		})
+	r.Register("Char", "ClearQuest", RequireChar,
+		func(ctx *ClientCallContext, p *QuestIdParams) {
+			ctx.Char.ClearQuest(p.Qid)
+		})
+	// snapshot
+	r.Register("Char", "AckViewSnapshot", RequireChar,
+		func(ctx *ClientCallContext, p *SeqParams) {
+			ctx.Char.AckViewSnapshot(p.Seq)
+		})
+}
@@ -0,0 +1,111 @@
+package dao
+
+import (
+	"strconv"
+	"strings"
+)
+
+// optional protocol features, enabled per conn when
+// client says it supports them in hello.
+const (
+	ProtocolFeatureViewSnapshot = "viewSnapshot"
+	ProtocolFeatureResume       = "resume"
+)
+
+var ProtocolFeatures = []string{
+	ProtocolFeatureViewSnapshot,
+	ProtocolFeatureResume,
+}
+
+type ClientHello struct {
+	Version  string
+	features map[string]struct{}
+}
+
+func (conn *wsConn) HasFeature(name string) bool {
+	if conn.hello == nil {
+		return false
+	}
+	_, ok := conn.hello.features[name]
+	return ok
+}
+
+// compare dot separated versions like 0.0.10 > 0.0.9,
+// not number part compare as string.
+func CompareVersions(a string, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		ap, bp := "0", "0"
+		if i < len(as) {
+			ap = as[i]
+		}
+		if i < len(bs) {
+			bp = bs[i]
+		}
+		an, aErr := strconv.Atoi(ap)
+		bn, bErr := strconv.Atoi(bp)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case ap != bp:
+			if ap < bp {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (w *World) Hello(version string, features []string, conn *wsConn) {
+	configs := w.configs.ServerConfigs
+	if CompareVersions(version, configs.MinClientVersion) < 0 {
+		w.RefuseStaleClient(conn, version)
+		return
+	}
+	hello := &ClientHello{
+		Version:  version,
+		features: make(map[string]struct{}, len(features)),
+	}
+	enabled := make([]string, 0, len(features))
+	for _, f := range features {
+		for _, supported := range ProtocolFeatures {
+			if f == supported {
+				hello.features[f] = struct{}{}
+				enabled = append(enabled, f)
+				break
+			}
+		}
+	}
+	conn.hello = hello
+	conn.SendClientCall(&ClientCall{
+		Receiver: "world",
+		Method:   "handleHello",
+		Params: []interface{}{map[string]interface{}{
+			"serverVersion": configs.ClientVersion,
+			"features":      enabled,
+			"codec":         conn.codec.Name(),
+		}},
+	})
+}
+
+// client without hello or too old one, reply and close it.
+func (w *World) RefuseStaleClient(conn *wsConn, version string) {
+	configs := w.configs.ServerConfigs
+	conn.CloseWithClientCalls([]*ClientCall{&ClientCall{
+		Receiver: "world",
+		Method:   "handleUpgradeRequired",
+		Params: []interface{}{map[string]interface{}{
+			"clientVersion":    version,
+			"minClientVersion": configs.MinClientVersion,
+			"serverVersion":    configs.ClientVersion,
+			"message":          "client is outdated, please upgrade.",
+		}},
+	}})
+}
@@ -0,0 +1,243 @@
+package dao
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// what a connection must have before a handler is called.
+type ClientCallRequire int
+
+const (
+	RequireNone ClientCallRequire = iota
+	RequireNoAccount
+	RequireAccount
+	RequireChar
+)
+
+type ClientCallContext struct {
+	World   *World
+	Conn    *wsConn
+	Account *Account
+	Char    *Char
+	Call    *ClientCall
+}
+
+// params struct can implement it to check values after decoded.
+type ClientCallParamsValidator interface {
+	Validate() error
+}
+
+type clientCallParam struct {
+	name     string
+	index    int
+	optional bool
+}
+
+type ClientCallHandler struct {
+	Receiver string
+	Method   string
+	Require  ClientCallRequire
+	// nil when handler has no params
+	paramsType reflect.Type
+	params     []*clientCallParam
+	fn         reflect.Value
+}
+
+type ClientCallRegistry struct {
+	handlers  map[string]*ClientCallHandler
+	receivers map[string]struct{}
+	// keep register order, for schema
+	order []*ClientCallHandler
+}
+
+var clientCallContextType = reflect.TypeOf((*ClientCallContext)(nil))
+
+func NewClientCallRegistry() *ClientCallRegistry {
+	return &ClientCallRegistry{
+		handlers:  make(map[string]*ClientCallHandler),
+		receivers: make(map[string]struct{}),
+		order:     make([]*ClientCallHandler, 0),
+	}
+}
+
+// fn must be func(*ClientCallContext) or
+// func(*ClientCallContext, *SomeParams), params are decoded to
+// SomeParams's fields by position, field use tag like
+// `param:"name,optional"`.
+func (r *ClientCallRegistry) Register(receiver string, method string, require ClientCallRequire, fn interface{}) {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func ||
+		ft.NumIn() < 1 || ft.NumIn() > 2 ||
+		ft.In(0) != clientCallContextType {
+		panic("dao: bad client call handler " + receiver + "." + method)
+	}
+	h := &ClientCallHandler{
+		Receiver: receiver,
+		Method:   method,
+		Require:  require,
+		fn:       fv,
+	}
+	if ft.NumIn() == 2 {
+		pt := ft.In(1)
+		if pt.Kind() != reflect.Ptr || pt.Elem().Kind() != reflect.Struct {
+			panic("dao: client call params must be struct pointer " + receiver + "." + method)
+		}
+		h.paramsType = pt.Elem()
+		h.params = parseClientCallParams(h.paramsType)
+	}
+	key := receiver + "." + method
+	if _, ok := r.handlers[key]; ok {
+		panic("dao: duplicate client call handler " + key)
+	}
+	r.handlers[key] = h
+	r.receivers[receiver] = struct{}{}
+	r.order = append(r.order, h)
+}
+
+func parseClientCallParams(t reflect.Type) []*clientCallParam {
+	params := make([]*clientCallParam, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		p := &clientCallParam{
+			name:  lowerFirst(field.Name),
+			index: i,
+		}
+		tag := field.Tag.Get("param")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		if opts[0] != "" {
+			p.name = opts[0]
+		}
+		for _, opt := range opts[1:] {
+			if opt == "optional" {
+				p.optional = true
+			}
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func (r *ClientCallRegistry) Find(receiver string, method string) *ClientCallHandler {
+	return r.handlers[receiver+"."+method]
+}
+
+func (r *ClientCallRegistry) Handlers() []*ClientCallHandler {
+	return r.order
+}
+
+func (r *ClientCallRegistry) Dispatch(ctx *ClientCallContext) *ClientCallError {
+	c := ctx.Call
+	h := r.Find(c.Receiver, c.Method)
+	if h == nil {
+		if _, ok := r.receivers[c.Receiver]; !ok {
+			return NewClientCallError(c, ClientCallErrUnknownReceiver, c.Receiver)
+		}
+		return NewClientCallError(c, ClientCallErrMethodNotFound, c.Receiver+"."+c.Method)
+	}
+	if err := h.checkRequire(ctx); err != nil {
+		return err
+	}
+	in := []reflect.Value{reflect.ValueOf(ctx)}
+	if h.paramsType != nil {
+		params, err := h.DecodeParams(c.Params)
+		if err != nil {
+			return NewClientCallError(c, ClientCallErrInvalidParams, err.Error())
+		}
+		in = append(in, params)
+	} else if len(c.Params) > 0 {
+		return NewClientCallError(c, ClientCallErrInvalidParams, "too many params")
+	}
+	h.fn.Call(in)
+	return nil
+}
+
+func (h *ClientCallHandler) checkRequire(ctx *ClientCallContext) *ClientCallError {
+	c := ctx.Call
+	acc := ctx.Account
+	switch h.Require {
+	case RequireNoAccount:
+		if acc != nil {
+			return NewClientCallError(c, ClientCallErrAlreadyLoggedIn, "account already logined")
+		}
+	case RequireAccount:
+		if acc == nil {
+			return NewClientCallError(c, ClientCallErrNotLoggedIn, "account not logined")
+		}
+	case RequireChar:
+		if acc == nil {
+			return NewClientCallError(c, ClientCallErrNotLoggedIn, "account not logined")
+		}
+		ctx.Char = acc.UsingChar()
+		if ctx.Char == nil {
+			return NewClientCallError(c, ClientCallErrNoUsingChar, "char not logined")
+		}
+	}
+	return nil
+}
+
+// params are decoded like encoding/json do, so bool,
+// nested object and slice all work. fractional number
+// for int param is truncated as CastJSON did.
+func (h *ClientCallHandler) DecodeParams(raw []interface{}) (reflect.Value, error) {
+	pv := reflect.New(h.paramsType)
+	if len(raw) > len(h.params) {
+		return pv, errors.New("too many params")
+	}
+	for i, p := range h.params {
+		if i >= len(raw) || raw[i] == nil {
+			if p.optional {
+				continue
+			}
+			return pv, errors.New("missing param " + p.name)
+		}
+		field := pv.Elem().Field(p.index)
+		b, err := json.Marshal(truncIntParam(field.Kind(), raw[i]))
+		if err != nil {
+			return pv, errors.New("param " + p.name + ": " + err.Error())
+		}
+		err = json.Unmarshal(b, field.Addr().Interface())
+		if err != nil {
+			return pv, errors.New("param " + p.name + ": " + err.Error())
+		}
+	}
+	if v, ok := pv.Interface().(ClientCallParamsValidator); ok {
+		if err := v.Validate(); err != nil {
+			return pv, err
+		}
+	}
+	return pv, nil
+}
+
+func truncIntParam(k reflect.Kind, raw interface{}) interface{} {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return raw
+	}
+	switch v := raw.(type) {
+	case float64:
+		return math.Trunc(v)
+	case float32:
+		return math.Trunc(float64(v))
+	}
+	return raw
+}
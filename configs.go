@@ -21,9 +21,15 @@ type UseSelfItemConfigs struct {
 	MaxStackCount int `yaml:"maxStackCount"`
 }
 
+type ItemDupScanConfigs struct {
+	Enable          bool `yaml:"enable"`
+	IntervalSeconds int  `yaml:"intervalSeconds"`
+}
+
 type ItemConfigs struct {
 	EtcItemConfigs     *EtcItemConfigs     `yaml:"etcItem"`
 	UseSelfItemConfigs *UseSelfItemConfigs `yaml:"useSelfItem"`
+	DupScan            *ItemDupScanConfigs `yaml:"dupScan"`
 }
 
 type CharFirstScene struct {
@@ -41,6 +47,8 @@ type CharConfigs struct {
 
 type AccountConfigs struct {
 	MaxChars int `yaml:"maxChars"`
+	// 0 logout when socket closed.
+	ResumeGraceSeconds int `yaml:"resumeGraceSeconds"`
 }
 
 type MongoDBConfigs struct {
@@ -48,6 +56,38 @@ type MongoDBConfigs struct {
 	DBName string `yaml:"dbName"`
 }
 
+type StorageConfigs struct {
+	// mongo, bolt or memory, memory one lost all data when server closed.
+	Backend string `yaml:"backend"`
+	// single file db for bolt backend.
+	BoltPath string               `yaml:"boltPath"`
+	Persist  *PersistConfigs      `yaml:"persist"`
+	Snapshot *CharSnapshotConfigs `yaml:"snapshot"`
+	Ledger   *LedgerConfigs       `yaml:"ledger"`
+}
+
+type LedgerConfigs struct {
+	Enable              bool `yaml:"enable"`
+	BufferSize          int  `yaml:"bufferSize"`
+	BatchSize           int  `yaml:"batchSize"`
+	FlushIntervalMillis int  `yaml:"flushIntervalMillis"`
+}
+
+type CharSnapshotConfigs struct {
+	Enable bool `yaml:"enable"`
+	// snapshots kept per char.
+	Keep int `yaml:"keep"`
+	// min interval of snapshots before risky operations.
+	RiskyIntervalSeconds int `yaml:"riskyIntervalSeconds"`
+}
+
+type PersistConfigs struct {
+	Workers    int `yaml:"workers"`
+	MaxRetries int `yaml:"maxRetries"`
+	// grows linearly each retry.
+	RetryDelayMillis int `yaml:"retryDelayMillis"`
+}
+
 type WorldConfigs struct {
 	Name string `yaml:"name"`
 }
@@ -80,6 +120,15 @@ func (conf *SceneConfigs) SetScenes(scenes map[string]*Scene) {
 			scene.autoClearItemDuration = conf.Default.AutoClearItemDuration
 			scene.autoSaveCharsDuration = conf.Default.AutoClearItemDuration
 		}
+		if scene.def != nil && scene.def.Settings != nil {
+			settings := scene.def.Settings
+			if settings.AutoClearItemDuration > 0 {
+				scene.autoClearItemDuration = settings.AutoClearItemDuration
+			}
+			if settings.AutoSaveCharsDuration > 0 {
+				scene.autoSaveCharsDuration = settings.AutoSaveCharsDuration
+			}
+		}
 		if conf.Custom != nil {
 			customConfig, ok := conf.Custom[name]
 			if ok {
@@ -90,12 +139,44 @@ func (conf *SceneConfigs) SetScenes(scenes map[string]*Scene) {
 	}
 }
 
+type RateLimitConfig struct {
+	Rate  float64 `yaml:"rate"`
+	Burst float64 `yaml:"burst"`
+	// not taken from connection bucket, for calls client sends every tick.
+	SkipConnection bool `yaml:"skipConnection,omitempty"`
+}
+
+type RateLimitConfigs struct {
+	Enable     bool             `yaml:"enable"`
+	Connection *RateLimitConfig `yaml:"connection"`
+	Default    *RateLimitConfig `yaml:"default"`
+	// key is Receiver.Method like Char.Move
+	Methods               map[string]*RateLimitConfig `yaml:"methods,omitempty"`
+	WarnAfter             int                         `yaml:"warnAfter"`
+	KickAfter             int                         `yaml:"kickAfter"`
+	ViolationResetSeconds int                         `yaml:"violationResetSeconds"`
+}
+
+const DefaultClientVersion = "0.0.1"
+
 type ServerConfigs struct {
 	HttpPort      int    `yaml:"httpPort"`
 	WebsocketPort int    `yaml:"websocketPort"`
 	EnableOauth2  bool   `yaml:"enableOauth2"`
 	SessionKey    string `yaml:"sessionKey"`
 	ClientVersion string `yaml:"clientVersion"`
+	// older clients get handleUpgradeRequired
+	MinClientVersion string `yaml:"minClientVersion"`
+	RequireHello     bool   `yaml:"requireHello"`
+	// slow client
+	SendQueueSize       int    `yaml:"sendQueueSize"`
+	SlowClientPolicy    string `yaml:"slowClientPolicy"`
+	SlowClientThreshold int    `yaml:"slowClientThreshold"`
+	// false send each call when it happen
+	BatchClientCalls  bool `yaml:"batchClientCalls"`
+	EnableCompression bool `yaml:"enableCompression"`
+	//
+	RateLimit *RateLimitConfigs `yaml:"rateLimit"`
 }
 
 type DaoConfigs struct {
@@ -103,6 +184,7 @@ type DaoConfigs struct {
 	AccountConfigs  *AccountConfigs
 	WorldConfigs    *WorldConfigs
 	MongoDBConfigs  *MongoDBConfigs
+	StorageConfigs  *StorageConfigs
 	ServerConfigs   *ServerConfigs
 	ItemConfigs     *ItemConfigs
 	SceneConfigs    *SceneConfigs
@@ -123,7 +205,8 @@ func NewDaoConfigs(dirPrefix string) *DaoConfigs {
 			},
 		},
 		AccountConfigs: &AccountConfigs{
-			MaxChars: 5,
+			MaxChars:           5,
+			ResumeGraceSeconds: 30,
 		},
 		WorldConfigs: &WorldConfigs{
 			Name: "develop",
@@ -132,11 +215,55 @@ func NewDaoConfigs(dirPrefix string) *DaoConfigs {
 			URL:    "127.0.0.1",
 			DBName: "dao",
 		},
+		StorageConfigs: &StorageConfigs{
+			Backend:  StorageBackendMongo,
+			BoltPath: "db/dao.bolt",
+			Persist: &PersistConfigs{
+				Workers:          4,
+				MaxRetries:       3,
+				RetryDelayMillis: 500,
+			},
+			Snapshot: &CharSnapshotConfigs{
+				Enable:               true,
+				Keep:                 20,
+				RiskyIntervalSeconds: 60,
+			},
+			Ledger: &LedgerConfigs{
+				Enable:              true,
+				BufferSize:          8192,
+				BatchSize:           256,
+				FlushIntervalMillis: 1000,
+			},
+		},
 		ServerConfigs: &ServerConfigs{
 			HttpPort:      3000,
 			WebsocketPort: 3000,
 			SessionKey:    "DaoSecret",
-			ClientVersion: "0.0.1",
+			ClientVersion: DefaultClientVersion,
+			//
+			MinClientVersion: DefaultClientVersion,
+			RequireHello:     true,
+			//
+			SendQueueSize:       256,
+			SlowClientPolicy:    SlowClientCoalesce,
+			SlowClientThreshold: 1024,
+			BatchClientCalls:    true,
+			EnableCompression:   false,
+			//
+			RateLimit: &RateLimitConfigs{
+				Enable:     true,
+				Connection: &RateLimitConfig{Rate: 60, Burst: 120},
+				Default:    &RateLimitConfig{Rate: 20, Burst: 40},
+				Methods: map[string]*RateLimitConfig{
+					"Char.Move":      &RateLimitConfig{Rate: 30, Burst: 60},
+					"Char.TalkScene": &RateLimitConfig{Rate: 1, Burst: 5},
+					// server sends view snapshot every tick.
+					"Char.AckViewSnapshot": &RateLimitConfig{Rate: 90, Burst: 180, SkipConnection: true},
+				},
+				WarnAfter:             20,
+				KickAfter:             200,
+				ViolationResetSeconds: 10,
+			},
 		},
 		ItemConfigs: &ItemConfigs{
 			EtcItemConfigs: &EtcItemConfigs{
@@ -145,6 +272,10 @@ func NewDaoConfigs(dirPrefix string) *DaoConfigs {
 			UseSelfItemConfigs: &UseSelfItemConfigs{
 				MaxStackCount: 100,
 			},
+			DupScan: &ItemDupScanConfigs{
+				Enable:          true,
+				IntervalSeconds: 600,
+			},
 		},
 		SceneConfigs: &SceneConfigs{
 			Default: &SceneBaseConfig{
@@ -164,6 +295,7 @@ func NewDaoConfigs(dirPrefix string) *DaoConfigs {
 		dc.ConfigDirPrefix + "conf/account.yaml": dc.AccountConfigs,
 		dc.ConfigDirPrefix + "conf/world.yaml":   dc.WorldConfigs,
 		dc.ConfigDirPrefix + "conf/mongodb.yaml": dc.MongoDBConfigs,
+		dc.ConfigDirPrefix + "conf/storage.yaml": dc.StorageConfigs,
 		dc.ConfigDirPrefix + "conf/server.yaml":  dc.ServerConfigs,
 		dc.ConfigDirPrefix + "conf/item.yaml":    dc.ItemConfigs,
 		dc.ConfigDirPrefix + "conf/scene.yaml":   dc.SceneConfigs,
@@ -52,6 +52,18 @@ func ToCpBodyClient(body *chipmunk.Body) *CpBodyClient {
 					realShape.B.Y,
 				},
 			}
+		case *chipmunk.PolygonShape:
+			verts := make([]*CpVectClient, len(realShape.Verts))
+			for j, v := range realShape.Verts {
+				verts[j] = &CpVectClient{v.X, v.Y}
+			}
+			shapeClient = map[string]interface{}{
+				"type":   "polygon",
+				"group":  shape.Group,
+				"layer":  shape.Layer,
+				"sensor": shape.IsSensor,
+				"verts":  verts,
+			}
 		}
 		shapeClients[i] = shapeClient
 	}
@@ -1,12 +1,28 @@
 package main
 
 import (
+    "os"
     "runtime"
     "github.com/XuHaoJun/dao"
+    "github.com/XuHaoJun/dao/loadtest"
 )
 
 func main() {
     runtime.GOMAXPROCS(runtime.NumCPU())
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "loadtest":
+            os.Exit(loadtest.Main(os.Args[2:]))
+        case "migrate":
+            os.Exit(dao.MigrateMain(os.Args[2:]))
+        case "export":
+            os.Exit(dao.ExportMain(os.Args[2:]))
+        case "import":
+            os.Exit(dao.ImportMain(os.Args[2:]))
+        case "protocol-check":
+            os.Exit(dao.ProtocolCheckMain(os.Args[2:]))
+        }
+    }
     server, err := dao.NewServer()
     if err != nil {
         panic(err)
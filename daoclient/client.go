@@ -0,0 +1,424 @@
+// Package daoclient is a headless client of dao's websocket protocol,
+// for bots, tools and integration tests.
+package daoclient
+
+import (
+	"errors"
+	"github.com/XuHaoJun/dao"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrClosed = errors.New("daoclient: closed")
+
+// UpgradeRequiredError is returned by Dial when server refused
+// client version.
+type UpgradeRequiredError struct {
+	*UpgradeRequiredEvent
+}
+
+func (e *UpgradeRequiredError) Error() string {
+	return "daoclient: upgrade required, min version " + e.MinClientVersion
+}
+
+type Options struct {
+	// dao.JSONClientCallCodecName or dao.MsgpackClientCallCodecName
+	Codec string
+	// ack handleViewSnapshot for user, so deltas keep small.
+	AutoAckViewSnapshot bool
+	// only latest seq acked, at most once per it.
+	ViewSnapshotAckInterval time.Duration
+	EventBufferSize         int
+	// sent in hello
+	ClientVersion string
+	Features      []string
+	HelloTimeout  time.Duration
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		Codec:                   dao.JSONClientCallCodecName,
+		AutoAckViewSnapshot:     true,
+		ViewSnapshotAckInterval: 100 * time.Millisecond,
+		EventBufferSize:         1024,
+		ClientVersion:           dao.DefaultClientVersion,
+		Features:                dao.ProtocolFeatures,
+		HelloTimeout:            10 * time.Second,
+	}
+}
+
+type Client struct {
+	ws      *websocket.Conn
+	codec   dao.ClientCallCodec
+	options *Options
+	// Events is closed when connection closed,
+	// read it or read loop will block.
+	Events     chan *Event
+	writeMutex sync.Mutex
+	nextId     int64
+	stats      ClientStats
+	hello      *HelloEvent
+	// view snapshot ack, only used by read loop.
+	snapshotSeq   int
+	ackedSeq      int
+	lastAckedTime time.Time
+	// set by Logout, read loop resets seqs on next event.
+	snapshotSeqStale int32
+	//
+	stateMutex  sync.Mutex
+	username    string
+	resumeToken string
+	err         error
+	quit        chan struct{}
+	closeOnce   sync.Once
+}
+
+// url like ws://127.0.0.1:3000/daows
+func Dial(url string, options *Options) (*Client, error) {
+	if options == nil {
+		options = DefaultOptions()
+	}
+	if options.Codec == "" {
+		options.Codec = dao.JSONClientCallCodecName
+	}
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     []string{options.Codec},
+	}
+	ws, _, err := dialer.Dial(url, http.Header{})
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		ws:      ws,
+		codec:   dao.ClientCallCodecByName(ws.Subprotocol()),
+		options: options,
+		Events:  make(chan *Event, options.EventBufferSize),
+		quit:    make(chan struct{}),
+	}
+	go c.readRun()
+	err = c.sayHello()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) sayHello() error {
+	err := c.Call("World", "Hello", c.options.ClientVersion, c.options.Features)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(c.options.HelloTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case e, ok := <-c.Events:
+			if !ok {
+				return ErrClosed
+			}
+			switch payload := e.Payload.(type) {
+			case *HelloEvent:
+				c.hello = payload
+				return nil
+			case *UpgradeRequiredEvent:
+				return &UpgradeRequiredError{payload}
+			}
+		case <-timer.C:
+			return errors.New("daoclient: hello timeout")
+		}
+	}
+}
+
+// Hello is what server replied, features enabled for this conn.
+func (c *Client) Hello() *HelloEvent {
+	return c.hello
+}
+
+type ClientStats struct {
+	SentCalls      int64
+	ReceivedFrames int64
+	ReceivedCalls  int64
+	ReceivedBytes  int64
+}
+
+func (c *Client) Stats() ClientStats {
+	return ClientStats{
+		SentCalls:      atomic.LoadInt64(&c.stats.SentCalls),
+		ReceivedFrames: atomic.LoadInt64(&c.stats.ReceivedFrames),
+		ReceivedCalls:  atomic.LoadInt64(&c.stats.ReceivedCalls),
+		ReceivedBytes:  atomic.LoadInt64(&c.stats.ReceivedBytes),
+	}
+}
+
+func (c *Client) Codec() string {
+	return c.codec.Name()
+}
+
+func (c *Client) Username() string {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return c.username
+}
+
+func (c *Client) ResumeToken() string {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return c.resumeToken
+}
+
+// Err is why read loop stopped.
+func (c *Client) Err() error {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return c.err
+}
+
+func (c *Client) readRun() {
+	defer close(c.Events)
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			c.stateMutex.Lock()
+			c.err = err
+			c.stateMutex.Unlock()
+			c.Close()
+			return
+		}
+		atomic.AddInt64(&c.stats.ReceivedFrames, 1)
+		atomic.AddInt64(&c.stats.ReceivedBytes, int64(len(msg)))
+		calls, err := c.codec.UnmarshalCalls(msg)
+		if err != nil {
+			continue
+		}
+		atomic.AddInt64(&c.stats.ReceivedCalls, int64(len(calls)))
+		for _, call := range calls {
+			e := NewEvent(call)
+			c.onEvent(e)
+			select {
+			case c.Events <- e:
+			case <-c.quit:
+				return
+			}
+		}
+		c.ackViewSnapshotLatest()
+	}
+}
+
+func (c *Client) ackViewSnapshotLatest() {
+	if !c.options.AutoAckViewSnapshot || c.snapshotSeq <= c.ackedSeq ||
+		time.Since(c.lastAckedTime) < c.options.ViewSnapshotAckInterval {
+		return
+	}
+	if c.AckViewSnapshot(c.snapshotSeq) == nil {
+		c.ackedSeq = c.snapshotSeq
+		c.lastAckedTime = time.Now()
+	}
+}
+
+// seqs restart for every char login on server.
+func (c *Client) resetViewSnapshotSeq() {
+	c.snapshotSeq = 0
+	c.ackedSeq = 0
+	c.lastAckedTime = time.Time{}
+}
+
+func (c *Client) onEvent(e *Event) {
+	if atomic.CompareAndSwapInt32(&c.snapshotSeqStale, 1, 0) {
+		c.resetViewSnapshotSeq()
+	}
+	switch payload := e.Payload.(type) {
+	case *LoginAccountEvent:
+		c.stateMutex.Lock()
+		c.username = payload.Username
+		c.resumeToken = payload.ResumeToken
+		c.stateMutex.Unlock()
+	case *LoginCharEvent:
+		c.resetViewSnapshotSeq()
+	case *dao.ViewSnapshotDeltaClient:
+		if payload.Seq > c.snapshotSeq {
+			c.snapshotSeq = payload.Seq
+		}
+	case *PongEvent:
+		payload.RTT = time.Since(msToTime(payload.T))
+	}
+}
+
+// Call send without id, server never tell it failed.
+func (c *Client) Call(receiver string, method string, params ...interface{}) error {
+	return c.send(&dao.ClientCall{
+		Receiver: receiver,
+		Method:   method,
+		Params:   params,
+	})
+}
+
+// CallWithId return id, server send ErrorEvent with same id if call rejected.
+func (c *Client) CallWithId(receiver string, method string, params ...interface{}) (int, error) {
+	id := int(atomic.AddInt64(&c.nextId, 1))
+	err := c.send(&dao.ClientCall{
+		Receiver: receiver,
+		Method:   method,
+		Params:   params,
+		Id:       id,
+	})
+	return id, err
+}
+
+func (c *Client) send(call *dao.ClientCall) error {
+	if call.Params == nil {
+		call.Params = []interface{}{}
+	}
+	msg, err := c.codec.MarshalCall(call)
+	if err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	select {
+	case <-c.quit:
+		return ErrClosed
+	default:
+	}
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	err = c.ws.WriteMessage(c.codec.MessageType(), msg)
+	if err == nil {
+		atomic.AddInt64(&c.stats.SentCalls, 1)
+	}
+	return err
+}
+
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.quit)
+		err = c.ws.Close()
+	})
+	return err
+}
+
+// WaitFor drop other events until method come.
+func (c *Client) WaitFor(method string, timeout time.Duration) (*Event, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case e, ok := <-c.Events:
+			if !ok {
+				return nil, ErrClosed
+			}
+			if e.Method == method {
+				return e, nil
+			}
+		case <-timer.C:
+			return nil, errors.New("daoclient: wait " + method + " timeout")
+		}
+	}
+}
+
+// world
+
+// Ping server, PongEvent.RTT is round trip time include
+// waiting world loop.
+func (c *Client) Ping() error {
+	return c.Call("World", "Ping", timeToMs(time.Now()))
+}
+
+func timeToMs(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
+
+func msToTime(ms float64) time.Time {
+	return time.Unix(0, int64(ms*float64(time.Millisecond)))
+}
+
+func (c *Client) RegisterAccount(username string, password string, email string) error {
+	return c.Call("World", "RegisterAccount", username, password, email)
+}
+
+func (c *Client) LoginAccount(username string, password string) error {
+	return c.Call("World", "LoginAccount", username, password)
+}
+
+// ResumeSession use token from last login, for a new Client
+// use ResumeSessionBy.
+func (c *Client) ResumeSession() error {
+	return c.ResumeSessionBy(c.Username(), c.ResumeToken())
+}
+
+func (c *Client) ResumeSessionBy(username string, token string) error {
+	return c.Call("World", "ResumeSession", username, token)
+}
+
+// account
+
+func (c *Client) CreateChar(name string) error {
+	return c.Call("Account", "CreateChar", name)
+}
+
+func (c *Client) LoginChar(slot int) error {
+	return c.Call("Account", "LoginChar", slot)
+}
+
+func (c *Client) Logout() error {
+	atomic.StoreInt32(&c.snapshotSeqStale, 1)
+	return c.Call("Account", "Logout")
+}
+
+// char
+
+func (c *Client) Move(x float32, y float32) error {
+	return c.Call("Char", "Move", x, y)
+}
+
+func (c *Client) ShutDownMove() error {
+	return c.Call("Char", "ShutDownMove")
+}
+
+func (c *Client) TalkScene(content string) error {
+	return c.Call("Char", "TalkScene", content)
+}
+
+func (c *Client) UseSkillByBaseId(sid int) error {
+	return c.Call("Char", "UseSkillByBaseId", sid)
+}
+
+func (c *Client) PickItem(sbId int) error {
+	return c.Call("Char", "PickItem", sbId)
+}
+
+func (c *Client) UseFireBall() error {
+	return c.Call("Char", "UseFireBall")
+}
+
+func (c *Client) TalkNpcById(nid int) error {
+	return c.Call("Char", "TalkNpcById", nid)
+}
+
+func (c *Client) ResponseTalkingNpc(optIndex int) error {
+	return c.Call("Char", "ResponseTalkingNpc", optIndex)
+}
+
+func (c *Client) CancelTalkingNpc() error {
+	return c.Call("Char", "CancelTalkingNpc")
+}
+
+func (c *Client) BuyItemFromOpeningShop(sellIndex int) error {
+	return c.Call("Char", "BuyItemFromOpeningShop", sellIndex)
+}
+
+func (c *Client) SellItemToOpeningShop(baseId int, slotIndex int) error {
+	return c.Call("Char", "SellItemToOpeningShop", baseId, slotIndex)
+}
+
+func (c *Client) CancelOpeningShop() error {
+	return c.Call("Char", "CancelOpeningShop")
+}
+
+func (c *Client) AckViewSnapshot(seq int) error {
+	return c.Call("Char", "AckViewSnapshot", seq)
+}
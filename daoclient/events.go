@@ -0,0 +1,186 @@
+package daoclient
+
+import (
+	"encoding/json"
+	"github.com/XuHaoJun/dao"
+	"time"
+)
+
+// Event is one client call from server, Payload is typed
+// when method known, otherwise nil and use Call.Params.
+type Event struct {
+	Receiver string
+	Method   string
+	Call     *dao.ClientCall
+	Payload  interface{}
+	// decode error of Payload
+	Err error
+}
+
+type MessageEvent struct {
+	Message string
+}
+
+type LoginAccountEvent struct {
+	Username    string            `json:"username"`
+	CharConfigs []*dao.CharClient `json:"charConfigs"`
+	ResumeToken string            `json:"resumeToken"`
+	Resumed     bool              `json:"resumed"`
+}
+
+type CreateCharEvent struct {
+	CharConfig *dao.CharClient `json:"charConfig"`
+}
+
+type LoginCharEvent struct {
+	UsingChar int `json:"usingChar"`
+}
+
+type JoinSceneEvent struct {
+	SceneName string `json:"sceneName"`
+	Id        int    `json:"id"`
+}
+
+type PositionEvent struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+type RemoveByIdEvent struct {
+	Id        int
+	SceneName string
+}
+
+type MoveStateEvent struct {
+	Id        int
+	MoveState *dao.MoveStateClient
+}
+
+type HelloEvent struct {
+	ServerVersion string   `json:"serverVersion"`
+	Features      []string `json:"features"`
+	Codec         string   `json:"codec"`
+}
+
+type UpgradeRequiredEvent struct {
+	ClientVersion    string `json:"clientVersion"`
+	MinClientVersion string `json:"minClientVersion"`
+	ServerVersion    string `json:"serverVersion"`
+	Message          string `json:"message"`
+}
+
+type PongEvent struct {
+	T   float64
+	RTT time.Duration
+}
+
+// ItemsEvent is handleUpdateItems, items[type][slot],
+// nil item means slot cleared.
+type ItemsEvent struct {
+	Items   map[string]map[string]*dao.ItemClient
+	IsMerge bool
+}
+
+type RateLimitWarningEvent struct {
+	Receiver   string `json:"receiver"`
+	Method     string `json:"method"`
+	Violations int    `json:"violations"`
+}
+
+type eventDecoder func(params []interface{}) (interface{}, error)
+
+func decodeFirst(newPayload func() interface{}) eventDecoder {
+	return func(params []interface{}) (interface{}, error) {
+		payload := newPayload()
+		err := decodeParam(params, 0, payload)
+		return payload, err
+	}
+}
+
+var eventDecoders = map[string]eventDecoder{
+	"handleClientCallError":        decodeFirst(func() interface{} { return &dao.ClientCallError{} }),
+	"handleRateLimitWarning":       decodeFirst(func() interface{} { return &RateLimitWarningEvent{} }),
+	"handleSuccessRegisterAccount": decodeMessage,
+	"handleErrorLoginAccount":      decodeMessage,
+	"handleErrorResumeSession":     decodeMessage,
+	"handleErrorCreateChar":        decodeMessage,
+	"handleSuccessLoginAcccount":   decodeFirst(func() interface{} { return &LoginAccountEvent{} }),
+	"handleSuccessCreateChar":      decodeFirst(func() interface{} { return &CreateCharEvent{} }),
+	"handleSuccessLoginChar":       decodeFirst(func() interface{} { return &LoginCharEvent{} }),
+	"handleAddScene":               decodeFirst(func() interface{} { return &dao.SceneClient{} }),
+	"handleJoinScene":              decodeFirst(func() interface{} { return &JoinSceneEvent{} }),
+	"handleSetPosition":            decodeFirst(func() interface{} { return &PositionEvent{} }),
+	"handleViewSnapshot":           decodeFirst(func() interface{} { return &dao.ViewSnapshotDeltaClient{} }),
+	"handleChatMessage":            decodeFirst(func() interface{} { return &dao.ChatMessageClient{} }),
+	"handleNpcTalkBox":             decodeFirst(func() interface{} { return &dao.NpcTalkClient{} }),
+	"handleAddChar":                decodeFirst(func() interface{} { return &dao.CharClientBasic{} }),
+	"handleAddMob":                 decodeFirst(func() interface{} { return &dao.MobClientBasic{} }),
+	"handleAddNpc":                 decodeFirst(func() interface{} { return &dao.NpcClientBasic{} }),
+	"handleAddItem":                decodeFirst(func() interface{} { return &dao.ItemClient{} }),
+	"handleHello":                  decodeFirst(func() interface{} { return &HelloEvent{} }),
+	"handleUpgradeRequired":        decodeFirst(func() interface{} { return &UpgradeRequiredEvent{} }),
+	"handleShop":                   decodeFirst(func() interface{} { return &dao.ShopClient{} }),
+	"handlePong": func(params []interface{}) (interface{}, error) {
+		e := &PongEvent{}
+		err := decodeParam(params, 0, &e.T)
+		return e, err
+	},
+	"handleUpdateItems": func(params []interface{}) (interface{}, error) {
+		e := &ItemsEvent{}
+		err := decodeParam(params, 0, &e.Items)
+		if err != nil {
+			return e, err
+		}
+		err = decodeParam(params, 1, &e.IsMerge)
+		return e, err
+	},
+	"handleRemoveById": func(params []interface{}) (interface{}, error) {
+		e := &RemoveByIdEvent{}
+		err := decodeParam(params, 0, &e.Id)
+		if err != nil {
+			return e, err
+		}
+		err = decodeParam(params, 1, &e.SceneName)
+		return e, err
+	},
+	"handleMoveStateChange": func(params []interface{}) (interface{}, error) {
+		e := &MoveStateEvent{MoveState: &dao.MoveStateClient{}}
+		err := decodeParam(params, 0, &e.Id)
+		if err != nil {
+			return e, err
+		}
+		err = decodeParam(params, 1, e.MoveState)
+		return e, err
+	},
+}
+
+func decodeMessage(params []interface{}) (interface{}, error) {
+	e := &MessageEvent{}
+	err := decodeParam(params, 0, &e.Message)
+	return e, err
+}
+
+// params are json like values, round trip it to typed one.
+func decodeParam(params []interface{}, i int, v interface{}) error {
+	if i >= len(params) || params[i] == nil {
+		return nil
+	}
+	b, err := json.Marshal(params[i])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func NewEvent(call *dao.ClientCall) *Event {
+	e := &Event{
+		Receiver: call.Receiver,
+		Method:   call.Method,
+		Call:     call,
+	}
+	decode, ok := eventDecoders[call.Method]
+	if ok {
+		e.Payload, e.Err = decode(call.Params)
+	}
+	return e
+}
@@ -1,18 +1,21 @@
 package dao
 
 import (
-	"encoding/json"
 	"gopkg.in/mgo.v2"
-	"io/ioutil"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
 )
 
+// DaoDB is the mongo Storage.
 type DaoDB struct {
-	url      string
-	dbName   string
-	session  *mgo.Session
-	db       *mgo.Database
-	accounts *mgo.Collection
-	items    *mgo.Collection
+	url           string
+	dbName        string
+	session       *mgo.Session
+	db            *mgo.Database
+	accounts      *mgo.Collection
+	items         *mgo.Collection
+	charSnapshots *mgo.Collection
+	ledger        *mgo.Collection
 }
 
 func NewDaoDB(mgourl string, dbname string) (*DaoDB, error) {
@@ -22,47 +25,205 @@ func NewDaoDB(mgourl string, dbname string) (*DaoDB, error) {
 	}
 	db := mongoSession.DB(dbname)
 	daoDB := &DaoDB{
-		url:      mgourl,
-		dbName:   dbname,
-		session:  mongoSession,
-		db:       db,
-		accounts: db.C("accounts"),
-		items:    db.C("items"),
+		url:           mgourl,
+		dbName:        dbname,
+		session:       mongoSession,
+		db:            db,
+		accounts:      db.C("accounts"),
+		items:         db.C("items"),
+		charSnapshots: db.C("charSnapshots"),
+		ledger:        db.C("ledger"),
 	}
 	return daoDB, nil
 }
 
-func (d *DaoDB) UpdateAccountIndex() {
-	d.accounts.EnsureIndexKey("username")
+func mongoStorageErr(err error) error {
+	if err == mgo.ErrNotFound {
+		return ErrStorageNotFound
+	}
+	return err
+}
+
+func (d *DaoDB) FindAccount(username string) (*AccountDumpDB, error) {
+	doc, err := d.FindAccountDoc(username)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeAccountDoc(doc)
 }
 
-func (d *DaoDB) ImportDefaultJsonDB() error {
-	dat, err := ioutil.ReadFile("db/item_db.json")
-	var items []interface{}
-	err = json.Unmarshal(dat, &items)
+func (d *DaoDB) FindAccountDoc(username string) (bson.M, error) {
+	doc := bson.M{}
+	queryAcc := bson.M{"username": username}
+	err := d.accounts.Find(queryAcc).One(&doc)
 	if err != nil {
+		return nil, mongoStorageErr(err)
+	}
+	return doc, nil
+}
+
+func (d *DaoDB) AccountUsernames() ([]string, error) {
+	var accs []struct {
+		Username string `bson:"username"`
+	}
+	err := d.accounts.Find(nil).Select(bson.M{"username": 1}).All(&accs)
+	if err != nil {
+		return nil, err
+	}
+	usernames := make([]string, len(accs))
+	for i, acc := range accs {
+		usernames[i] = acc.Username
+	}
+	return usernames, nil
+}
+
+func (d *DaoDB) HasAccount(username string) (bool, error) {
+	queryAcc := bson.M{"username": username}
+	err := d.accounts.Find(queryAcc).Select(bson.M{"_id": 1}).One(&struct{}{})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *DaoDB) SaveAccount(dump *AccountDumpDB) error {
+	_, err := d.accounts.UpsertId(dump.Id, dump)
+	return err
+}
+
+func (d *DaoDB) SaveChar(accountId bson.ObjectId, slotIndex int, dump *CharDumpDB) error {
+	cii := "chars." + strconv.Itoa(slotIndex)
+	update := bson.M{"$set": bson.M{cii: dump}}
+	return mongoStorageErr(d.accounts.UpdateId(accountId, update))
+}
+
+func (d *DaoDB) HasCharName(name string) (bool, error) {
+	queryChar := bson.M{"chars": bson.M{"$elemMatch": bson.M{"name": name}}}
+	err := d.accounts.Find(queryChar).Select(bson.M{"_id": 1}).One(&struct{}{})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ImportItems fills a temp collection then renames it to items,
+// items untouched if anything fails.
+func (d *DaoDB) ImportItems(items []interface{}) error {
+	importing := d.db.C("items_importing")
+	importing.DropCollection()
+	if len(items) > 0 {
+		if err := importing.Insert(items...); err != nil {
+			return err
+		}
+	}
+	if err := importing.EnsureIndexKey("item.baseId"); err != nil {
+		return err
+	}
+	// dbName may be empty for default db, use the one mgo resolved.
+	rename := bson.D{
+		bson.DocElem{Name: "renameCollection", Value: d.db.Name + ".items_importing"},
+		bson.DocElem{Name: "to", Value: d.db.Name + ".items"},
+		bson.DocElem{Name: "dropTarget", Value: true},
+	}
+	return d.session.DB("admin").Run(rename, nil)
+}
+
+func (d *DaoDB) SaveCharSnapshot(snap *CharSnapshot, keep int) error {
+	if err := d.charSnapshots.Insert(snap); err != nil {
+		return err
+	}
+	if keep <= 0 {
+		return nil
+	}
+	var old []struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	err := d.charSnapshots.Find(bson.M{"charId": snap.CharId}).
+		Sort("-createdAt").Skip(keep).Select(bson.M{"_id": 1}).All(&old)
+	if err != nil || len(old) == 0 {
 		return err
 	}
-	d.items.DropCollection()
-	d.items.Insert(items...)
-	d.items.EnsureIndexKey("item.baseId")
-	return nil
+	ids := make([]bson.ObjectId, len(old))
+	for i, o := range old {
+		ids[i] = o.Id
+	}
+	_, err = d.charSnapshots.RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func (d *DaoDB) FindCharSnapshots(charId bson.ObjectId) ([]*CharSnapshot, error) {
+	var docs []bson.M
+	err := d.charSnapshots.Find(bson.M{"charId": charId}).
+		Sort("-createdAt").All(&docs)
+	if err != nil {
+		return nil, err
+	}
+	snaps := make([]*CharSnapshot, len(docs))
+	for i, doc := range docs {
+		snaps[i], err = DecodeCharSnapshotDoc(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snaps, nil
+}
+
+func (d *DaoDB) FindCharSnapshot(id bson.ObjectId) (*CharSnapshot, error) {
+	doc := bson.M{}
+	if err := d.charSnapshots.FindId(id).One(&doc); err != nil {
+		return nil, mongoStorageErr(err)
+	}
+	return DecodeCharSnapshotDoc(doc)
+}
+
+func (d *DaoDB) AppendLedger(entries []*LedgerEntry) error {
+	docs := make([]interface{}, len(entries))
+	for i, e := range entries {
+		docs[i] = e
+	}
+	return d.ledger.Insert(docs...)
+}
+
+func (d *DaoDB) FindLedger(q *LedgerQuery) ([]*LedgerEntry, error) {
+	entries := []*LedgerEntry{}
+	query := d.ledger.Find(q.BsonQuery()).Sort("-time")
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	if err := query.All(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (d *DaoDB) UpdateAccountIndex() {
+	d.accounts.EnsureIndexKey("username")
+	d.charSnapshots.EnsureIndexKey("charId", "-createdAt")
+	d.ledger.EnsureIndexKey("charName", "-time")
+	d.ledger.EnsureIndexKey("itemBaseId", "-time")
+	d.ledger.EnsureIndexKey("-time")
 }
 
 func (d *DaoDB) CloneSession() *DaoDB {
 	session := d.session.Clone()
 	db := session.DB(d.dbName)
 	d2 := &DaoDB{
-		url:      d.url,
-		dbName:   d.dbName,
-		session:  session,
-		db:       db,
-		accounts: db.C("accounts"),
-		items:    db.C("items"),
+		url:           d.url,
+		dbName:        d.dbName,
+		session:       session,
+		db:            db,
+		accounts:      db.C("accounts"),
+		items:         db.C("items"),
+		charSnapshots: db.C("charSnapshots"),
+		ledger:        db.C("ledger"),
 	}
 	return d2
 }
 
+func (d *DaoDB) Clone() Storage {
+	return d.CloneSession()
+}
+
 func (d *DaoDB) Close() {
 	d.session.Close()
 }
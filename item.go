@@ -25,6 +25,8 @@ type Itemer interface {
 	Owner() Bioer
 	SetOwner(Bioer)
 	BaseId() int
+	InstanceId() bson.ObjectId
+	SetInstanceId(bson.ObjectId)
 	SellPrice() int
 	SetSellPrice(price int)
 	BuyPrice() int
@@ -43,15 +45,18 @@ type Item struct {
 	bodyViewId int
 	buyPrice   int
 	sellPrice  int
+	// unique of every item created, shared by a stack.
+	instanceId bson.ObjectId
 }
 
 type ItemDumpDB struct {
-	Name       string `bson:"name"`
-	AgeisName  string `bson:"ageisName"`
-	IconViewId int    `bson:"iconViewId"`
-	BaseId     int    `bson:"baseId"`
-	BuyPrice   int    `bson:"buyPrice"`
-	SellPrice  int    `bson:"sellPrice"`
+	Name       string        `bson:"name"`
+	AgeisName  string        `bson:"ageisName"`
+	IconViewId int           `bson:"iconViewId"`
+	BaseId     int           `bson:"baseId"`
+	BuyPrice   int           `bson:"buyPrice"`
+	SellPrice  int           `bson:"sellPrice"`
+	InstanceId bson.ObjectId `bson:"instanceId,omitempty"`
 }
 
 func (i *Item) DumpDB() *ItemDumpDB {
@@ -62,6 +67,7 @@ func (i *Item) DumpDB() *ItemDumpDB {
 		BaseId:     i.baseId,
 		BuyPrice:   i.buyPrice,
 		SellPrice:  i.sellPrice,
+		InstanceId: i.instanceId,
 	}
 }
 
@@ -77,6 +83,7 @@ func (idump *ItemDumpDB) Load() *Item {
 	item.baseId = idump.BaseId
 	item.buyPrice = idump.BuyPrice
 	item.sellPrice = idump.SellPrice
+	item.instanceId = idump.InstanceId
 	return item
 }
 
@@ -205,6 +212,17 @@ func ItemTypeByBaseId(baseId int) string {
 	return iType
 }
 
+// ItemCount is how many items in the stack.
+func ItemCount(item Itemer) int {
+	switch it := item.(type) {
+	case *UseSelfItem:
+		return it.stackCount + 1
+	case *EtcItem:
+		return it.stackCount + 1
+	}
+	return 1
+}
+
 func (i *Item) ItemTypeByBaseId() string {
 	return ItemTypeByBaseId(i.baseId)
 }
@@ -213,6 +231,14 @@ func (i *Item) BaseId() int {
 	return i.baseId
 }
 
+func (i *Item) InstanceId() bson.ObjectId {
+	return i.instanceId
+}
+
+func (i *Item) SetInstanceId(id bson.ObjectId) {
+	i.instanceId = id
+}
+
 type EquipmentClient struct {
 	ItemClient  *ItemClient               `json:"itemConfig"`
 	Level       int                       `json:"level"`
@@ -0,0 +1,150 @@
+package dao
+
+import (
+	"errors"
+	"gopkg.in/mgo.v2/bson"
+	"sort"
+)
+
+var ErrItemNotFound = errors.New("item: base id not found")
+
+// ItemCatalog is item templates of db/item_db.json,
+// never changed after built, reload builds a new one.
+type ItemCatalog struct {
+	configs      *ItemConfigs
+	equipments   map[int]*EquipmentDB
+	useSelfItems map[int]*UseSelfItemDumpDB
+	etcItems     map[int]*EtcItemDumpDB
+	// shop listing, built once.
+	clients map[int]interface{}
+	// for diff between catalogs.
+	docs    map[int]bson.M
+	baseIds []int
+	// as read from json, for importing to storage.
+	raw []interface{}
+}
+
+// NewItemCatalog validates items then builds catalog of them.
+func NewItemCatalog(items []interface{}, configs *ItemConfigs) (*ItemCatalog, error) {
+	if errs := ValidateItemDB(items); len(errs) > 0 {
+		return nil, errs
+	}
+	ic := &ItemCatalog{
+		configs:      configs,
+		equipments:   make(map[int]*EquipmentDB),
+		useSelfItems: make(map[int]*UseSelfItemDumpDB),
+		etcItems:     make(map[int]*EtcItemDumpDB),
+		clients:      make(map[int]interface{}, len(items)),
+		docs:         make(map[int]bson.M, len(items)),
+		baseIds:      make([]int, 0, len(items)),
+		raw:          items,
+	}
+	for _, item := range items {
+		dat, err := bson.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		key := &struct {
+			Item *ItemDumpDB `bson:"item"`
+		}{}
+		if err := bson.Unmarshal(dat, key); err != nil {
+			return nil, err
+		}
+		id := key.Item.BaseId
+		doc := bson.M{}
+		if err := bson.Unmarshal(dat, &doc); err != nil {
+			return nil, err
+		}
+		ic.docs[id] = doc
+		switch ItemTypeByBaseId(id) {
+		case "equipment":
+			eqDB := NewEquipment().DB()
+			err = bson.Unmarshal(dat, eqDB)
+			ic.equipments[id] = eqDB
+		case "useSelfItem":
+			useDump := NewUseSelfItem().DumpDB()
+			err = bson.Unmarshal(dat, useDump)
+			ic.useSelfItems[id] = useDump
+		case "etcItem":
+			etcDump := NewEtcItem().DumpDB()
+			err = bson.Unmarshal(dat, etcDump)
+			ic.etcItems[id] = etcDump
+		}
+		if err != nil {
+			return nil, err
+		}
+		ic.baseIds = append(ic.baseIds, id)
+	}
+	sort.Ints(ic.baseIds)
+	for _, id := range ic.baseIds {
+		item, _ := ic.NewItem(id)
+		ic.clients[id] = item.Client()
+	}
+	return ic, nil
+}
+
+func (ic *ItemCatalog) Has(id int) bool {
+	return ic.equipments[id] != nil ||
+		ic.useSelfItems[id] != nil ||
+		ic.etcItems[id] != nil
+}
+
+func (ic *ItemCatalog) BaseIds() []int {
+	ids := make([]int, len(ic.baseIds))
+	copy(ids, ic.baseIds)
+	return ids
+}
+
+func (ic *ItemCatalog) Len() int {
+	return len(ic.baseIds)
+}
+
+// NewItem returns a new item of template id,
+// onUse of useSelfItem not set, see World.NewItemByBaseId.
+func (ic *ItemCatalog) NewItem(id int) (item Itemer, err error) {
+	switch ItemTypeByBaseId(id) {
+	case "equipment":
+		eqDB, ok := ic.equipments[id]
+		if !ok {
+			return nil, ErrItemNotFound
+		}
+		// rolls bonus.
+		item = eqDB.DumpDB().Load()
+	case "useSelfItem":
+		useDump, ok := ic.useSelfItems[id]
+		if !ok {
+			return nil, ErrItemNotFound
+		}
+		dump := *useDump
+		dump.MaxStackCount = ic.configs.UseSelfItemConfigs.MaxStackCount
+		item = dump.Load()
+	case "etcItem":
+		etcDump, ok := ic.etcItems[id]
+		if !ok {
+			return nil, ErrItemNotFound
+		}
+		dump := *etcDump
+		dump.MaxStackCount = ic.configs.EtcItemConfigs.MaxStackCount
+		item = dump.Load()
+	}
+	if item.IconViewId() == 0 {
+		item.SetIconViewId(item.BaseId())
+	}
+	if item.BuyPrice() != 0 && item.SellPrice() == 0 {
+		item.SetSellPrice(int(float32(item.BuyPrice()) * 0.5))
+	}
+	return
+}
+
+func (ic *ItemCatalog) UseSelfFuncArrays(id int) []*UseSelfItemCall {
+	useDump, ok := ic.useSelfItems[id]
+	if !ok {
+		return nil
+	}
+	return useDump.UseSelfFuncArrays
+}
+
+// ItemClient is shared, don't modify it.
+func (ic *ItemCatalog) ItemClient(id int) interface{} {
+	return ic.clients[id]
+}
@@ -0,0 +1,228 @@
+package dao
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"strings"
+)
+
+type ItemDBError struct {
+	Index   int
+	BaseId  int
+	Message string
+}
+
+func (e *ItemDBError) Error() string {
+	return fmt.Sprintf("item_db[%d] baseId %d: %s", e.Index, e.BaseId, e.Message)
+}
+
+type ItemDBErrors []*ItemDBError
+
+func (es ItemDBErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return fmt.Sprintf("%d invalid items: %s", len(es), strings.Join(msgs, "; "))
+}
+
+// use self funcs receivers, same as UseSelfItemCall.FindFunc.
+var useSelfReceiverTypes = map[string]reflect.Type{
+	"Bio":   reflect.TypeOf((*Bioer)(nil)).Elem(),
+	"Util":  reflect.TypeOf(&Util{}),
+	"World": reflect.TypeOf(&World{}),
+	"Scene": reflect.TypeOf(&Scene{}),
+	"Item":  reflect.TypeOf(&UseSelfItem{}),
+	"Char":  reflect.TypeOf(&Char{}),
+}
+
+// fields an item type must not have.
+var itemTypeForbiddenFields = map[string][]string{
+	"equipment":   []string{"useSelfFuncs", "stackCount"},
+	"useSelfItem": []string{"etype", "bonusInfo", "equipLimit"},
+	"etcItem":     []string{"etype", "bonusInfo", "equipLimit", "useSelfFuncs"},
+}
+
+func bsonNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// ValidateItemDB checks every item of db/item_db.json.
+func ValidateItemDB(items []interface{}) ItemDBErrors {
+	errs := ItemDBErrors{}
+	seen := make(map[int]int, len(items))
+	for i, item := range items {
+		fail := func(baseId int, format string, a ...interface{}) {
+			errs = append(errs, &ItemDBError{i, baseId, fmt.Sprintf(format, a...)})
+		}
+		dat, err := bson.Marshal(item)
+		if err != nil {
+			fail(0, "not a document")
+			continue
+		}
+		doc := bson.M{}
+		if err := bson.Unmarshal(dat, &doc); err != nil {
+			fail(0, "not a document")
+			continue
+		}
+		itemDoc, ok := doc["item"].(bson.M)
+		if !ok {
+			fail(0, "missing item")
+			continue
+		}
+		n, ok := bsonNumber(itemDoc["baseId"])
+		if !ok || n != float64(int(n)) || n <= 0 {
+			fail(0, "baseId must be a positive integer")
+			continue
+		}
+		baseId := int(n)
+		if first, dup := seen[baseId]; dup {
+			fail(baseId, "duplicate baseId of item_db[%d]", first)
+		}
+		seen[baseId] = i
+		if name, _ := itemDoc["name"].(string); name == "" {
+			fail(baseId, "missing item.name")
+		}
+		iType := ItemTypeByBaseId(baseId)
+		for _, field := range itemTypeForbiddenFields[iType] {
+			if _, found := doc[field]; found {
+				fail(baseId, "baseId in %s range can't have %s", iType, field)
+			}
+		}
+		switch iType {
+		case "equipment":
+			etype, ok := bsonNumber(doc["etype"])
+			if !ok || etype < Helm || etype > Stick {
+				fail(baseId, "equipment needs etype in %d..%d", Helm, Stick)
+			}
+		case "useSelfItem":
+			calls, _ := doc["useSelfFuncs"].([]interface{})
+			for j, call := range calls {
+				if err := validateUseSelfCall(call, nil); err != "" {
+					fail(baseId, "useSelfFuncs[%d]: %s", j, err)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// validateUseSelfCall returns message of first problem,
+// ret is the kind nested call should return.
+func validateUseSelfCall(v interface{}, ret *reflect.Kind) string {
+	call, ok := v.(bson.M)
+	if !ok {
+		return "not a call"
+	}
+	receiver, _ := call["receiver"].(string)
+	method, _ := call["method"].(string)
+	rtype, ok := useSelfReceiverTypes[receiver]
+	if !ok {
+		return "unknown receiver " + receiver
+	}
+	m, ok := rtype.MethodByName(method)
+	if !ok {
+		return receiver + "." + method + " not found"
+	}
+	ftype := m.Type
+	offset := 0
+	if rtype.Kind() != reflect.Interface {
+		// method expression has receiver as first in.
+		offset = 1
+	}
+	params, _ := call["params"].([]interface{})
+	if len(params) != ftype.NumIn()-offset {
+		return fmt.Sprintf("%s.%s wants %d params, got %d",
+			receiver, method, ftype.NumIn()-offset, len(params))
+	}
+	if ret != nil {
+		if ftype.NumOut() == 0 || ftype.Out(0).Kind() != *ret {
+			return receiver + "." + method + " not returns " + ret.String()
+		}
+	}
+	for i, param := range params {
+		kind := ftype.In(i + offset).Kind()
+		if _, isCall := param.(bson.M); isCall {
+			if kind != reflect.Int && kind != reflect.String {
+				return fmt.Sprintf("param %d of %s.%s can't be a call", i, receiver, method)
+			}
+			if msg := validateUseSelfCall(param, &kind); msg != "" {
+				return msg
+			}
+			continue
+		}
+		_, isNumber := bsonNumber(param)
+		_, isString := param.(string)
+		switch kind {
+		case reflect.Int, reflect.Float32, reflect.Float64:
+			if !isNumber {
+				return fmt.Sprintf("param %d of %s.%s must be a number", i, receiver, method)
+			}
+		case reflect.String:
+			if !isString {
+				return fmt.Sprintf("param %d of %s.%s must be a string", i, receiver, method)
+			}
+		}
+	}
+	return ""
+}
+
+type ItemCatalogDiff struct {
+	Added   []int `json:"added"`
+	Changed []int `json:"changed"`
+	Removed []int `json:"removed"`
+	// removed ones npc shops or mob drops still use, reload refuses them.
+	RemovedInUse []int `json:"removedInUse"`
+}
+
+func DiffItemCatalog(old *ItemCatalog, new *ItemCatalog) *ItemCatalogDiff {
+	diff := &ItemCatalogDiff{
+		Added:   []int{},
+		Changed: []int{},
+		Removed: []int{},
+		//
+		RemovedInUse: []int{},
+	}
+	for _, id := range new.baseIds {
+		oldDoc, ok := old.docs[id]
+		if !ok {
+			diff.Added = append(diff.Added, id)
+		} else if !reflect.DeepEqual(oldDoc, new.docs[id]) {
+			diff.Changed = append(diff.Changed, id)
+		}
+	}
+	for _, id := range old.baseIds {
+		if !new.Has(id) {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	return diff
+}
+
+// CheckInUse fills RemovedInUse, inUse is from World.itemBaseIdsInUse.
+func (d *ItemCatalogDiff) CheckInUse(inUse map[int]string) {
+	d.RemovedInUse = []int{}
+	for _, id := range d.Removed {
+		if _, found := inUse[id]; found {
+			d.RemovedInUse = append(d.RemovedInUse, id)
+		}
+	}
+}
+
+func (d *ItemCatalogDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+func (d *ItemCatalogDiff) String() string {
+	return fmt.Sprintf("added %v, changed %v, removed %v, removed in use %v",
+		d.Added, d.Changed, d.Removed, d.RemovedInUse)
+}
@@ -0,0 +1,232 @@
+package dao
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ItemInstanceLocation is a slot an item instance found in.
+type ItemInstanceLocation struct {
+	Username string `json:"username"`
+	CharName string `json:"charName"`
+	Slot     string `json:"slot"`
+	BaseId   int    `json:"baseId"`
+	Online   bool   `json:"online"`
+}
+
+type ItemDuplicate struct {
+	InstanceId string                  `json:"instanceId"`
+	BaseId     int                     `json:"baseId"`
+	Locations  []*ItemInstanceLocation `json:"locations"`
+}
+
+func (dup *ItemDuplicate) String() string {
+	places := make([]string, len(dup.Locations))
+	for i, loc := range dup.Locations {
+		places[i] = loc.Username + "/" + loc.CharName + " " + loc.Slot
+	}
+	return "instance " + dup.InstanceId + " of item " +
+		strconv.Itoa(dup.BaseId) + " in " + strings.Join(places, ", ")
+}
+
+type ItemDupReport struct {
+	StartedAt  time.Time        `json:"startedAt"`
+	FinishedAt time.Time        `json:"finishedAt"`
+	Accounts   int              `json:"accounts"`
+	Items      int              `json:"items"`
+	Duplicates []*ItemDuplicate `json:"duplicates"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type itemInstanceIndex struct {
+	locations map[bson.ObjectId][]*ItemInstanceLocation
+	items     int
+}
+
+func newItemInstanceIndex() *itemInstanceIndex {
+	return &itemInstanceIndex{
+		locations: make(map[bson.ObjectId][]*ItemInstanceLocation),
+	}
+}
+
+func (idx *itemInstanceIndex) add(loc ItemInstanceLocation, item *ItemDumpDB) {
+	// not migrated yet.
+	if item == nil || item.InstanceId == "" {
+		return
+	}
+	loc.BaseId = item.BaseId
+	idx.locations[item.InstanceId] = append(idx.locations[item.InstanceId], &loc)
+	idx.items += 1
+}
+
+func (idx *itemInstanceIndex) addAccount(accDump *AccountDumpDB, online bool) {
+	for _, charDump := range accDump.Chars {
+		if charDump == nil {
+			continue
+		}
+		loc := ItemInstanceLocation{
+			Username: accDump.Username,
+			CharName: charDump.Name,
+			Online:   online,
+		}
+		slot := func(prefix string, i int) ItemInstanceLocation {
+			loc.Slot = prefix + "." + strconv.Itoa(i)
+			return loc
+		}
+		for i, eq := range charDump.UsingEquips {
+			if eq != nil {
+				idx.add(slot("usingEquips", i), eq.Item)
+			}
+		}
+		items := charDump.Items
+		if items == nil {
+			continue
+		}
+		for i, eq := range items.Equipment {
+			if eq != nil {
+				idx.add(slot("items.equipment", i), eq.Item)
+			}
+		}
+		for i, etc := range items.EtcItem {
+			if etc != nil {
+				idx.add(slot("items.etcItem", i), etc.Item)
+			}
+		}
+		for i, us := range items.UseSelfItem {
+			if us != nil {
+				idx.add(slot("items.useSelfItem", i), us.Item)
+			}
+		}
+	}
+}
+
+func (idx *itemInstanceIndex) duplicates() []*ItemDuplicate {
+	dups := []*ItemDuplicate{}
+	for id, locs := range idx.locations {
+		if len(locs) < 2 {
+			continue
+		}
+		dups = append(dups, &ItemDuplicate{
+			InstanceId: id.Hex(),
+			BaseId:     locs[0].BaseId,
+			Locations:  locs,
+		})
+	}
+	sort.Sort(itemDuplicatesById(dups))
+	return dups
+}
+
+type itemDuplicatesById []*ItemDuplicate
+
+func (ds itemDuplicatesById) Len() int           { return len(ds) }
+func (ds itemDuplicatesById) Swap(i, j int)      { ds[i], ds[j] = ds[j], ds[i] }
+func (ds itemDuplicatesById) Less(i, j int) bool { return ds[i].InstanceId < ds[j].InstanceId }
+
+// ItemDupScanner looks for an item instance in more than one slot.
+// Online accounts are dumped on world loop, stored ones are read
+// on scanner's own goroutine.
+type ItemDupScanner struct {
+	db      Storage
+	persist *PersistQueue
+	logger  *log.Logger
+	mutex   sync.Mutex
+	running bool
+	last    *ItemDupReport
+}
+
+func NewItemDupScanner(db Storage, persist *PersistQueue, logger *log.Logger) *ItemDupScanner {
+	return &ItemDupScanner{
+		db:      db,
+		persist: persist,
+		logger:  logger,
+	}
+}
+
+// Scan returns false if last scan not finished yet.
+func (s *ItemDupScanner) Scan(online []*AccountDumpDB) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.running {
+		return false
+	}
+	s.running = true
+	go s.scan(online)
+	return true
+}
+
+func (s *ItemDupScanner) scan(online []*AccountDumpDB) {
+	report := &ItemDupReport{StartedAt: time.Now()}
+	idx := newItemInstanceIndex()
+	onlineNames := make(map[string]bool, len(online))
+	for _, accDump := range online {
+		idx.addAccount(accDump, true)
+		onlineNames[accDump.Username] = true
+	}
+	report.Accounts = len(online)
+	if err := s.scanStorage(idx, onlineNames, report); err != nil {
+		report.Error = err.Error()
+		s.logger.Println("ItemDupScanner:", err)
+	}
+	report.Items = idx.items
+	report.Duplicates = idx.duplicates()
+	report.FinishedAt = time.Now()
+	for _, dup := range report.Duplicates {
+		s.logger.Println("ItemDupScanner: duplicated", dup)
+	}
+	s.mutex.Lock()
+	s.last = report
+	s.running = false
+	s.mutex.Unlock()
+}
+
+func (s *ItemDupScanner) scanStorage(idx *itemInstanceIndex, onlineNames map[string]bool, report *ItemDupReport) error {
+	db := s.db.Clone()
+	defer db.Close()
+	usernames, err := db.AccountUsernames()
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		// dumped from memory, newer than stored.
+		if onlineNames[username] {
+			continue
+		}
+		// may be saved just after logout.
+		s.persist.Wait(username)
+		accDump, err := db.FindAccount(username)
+		if err == ErrStorageNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		idx.addAccount(accDump, false)
+		report.Accounts += 1
+	}
+	return nil
+}
+
+// LastReport is nil before first scan finished.
+func (s *ItemDupScanner) LastReport() *ItemDupReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.last
+}
+
+// ScanItemDuplicates starts a scan in background,
+// runs on world loop by interval, also call it from REPL.
+func (w *World) ScanItemDuplicates() bool {
+	online := make([]*AccountDumpDB, 0, len(w.accounts))
+	for _, acc := range w.accounts {
+		online = append(online, acc.DumpDB())
+	}
+	return w.itemDupScanner.Scan(online)
+}
+
+func (w *World) ItemDupReport() *ItemDupReport {
+	return w.itemDupScanner.LastReport()
+}
@@ -0,0 +1,322 @@
+package dao
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	LedgerZeny = "zeny"
+	LedgerItem = "item"
+)
+
+const (
+	LedgerSourceKillMob  = "killMob"
+	LedgerSourceBuyShop  = "buyShop"
+	LedgerSourceSellShop = "sellShop"
+	LedgerSourcePickItem = "pickItem"
+	LedgerSourceDropItem = "dropItem"
+	LedgerSourceGetItem  = "getItem"
+)
+
+// LedgerEntry is one zeny or item movement, never updated.
+// Amount is positive when char gained.
+type LedgerEntry struct {
+	Id             bson.ObjectId `bson:"_id" json:"id"`
+	Time           time.Time     `bson:"time" json:"time"`
+	Username       string        `bson:"username" json:"username"`
+	CharId         bson.ObjectId `bson:"charId" json:"charId"`
+	CharName       string        `bson:"charName" json:"charName"`
+	Kind           string        `bson:"kind" json:"kind"`
+	Source         string        `bson:"source" json:"source"`
+	Amount         int           `bson:"amount" json:"amount"`
+	Balance        int           `bson:"balance" json:"balance"`
+	ItemBaseId     int           `bson:"itemBaseId,omitempty" json:"itemBaseId,omitempty"`
+	ItemName       string        `bson:"itemName,omitempty" json:"itemName,omitempty"`
+	ItemInstanceId bson.ObjectId `bson:"itemInstanceId,omitempty" json:"itemInstanceId,omitempty"`
+	SceneName      string        `bson:"sceneName" json:"sceneName"`
+	Detail         string        `bson:"detail,omitempty" json:"detail,omitempty"`
+}
+
+// LedgerQuery zero fields match anything.
+type LedgerQuery struct {
+	Username   string
+	CharName   string
+	Kind       string
+	Source     string
+	ItemBaseId int
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+func (q *LedgerQuery) Match(e *LedgerEntry) bool {
+	return (q.Username == "" || q.Username == e.Username) &&
+		(q.CharName == "" || q.CharName == e.CharName) &&
+		(q.Kind == "" || q.Kind == e.Kind) &&
+		(q.Source == "" || q.Source == e.Source) &&
+		(q.ItemBaseId == 0 || q.ItemBaseId == e.ItemBaseId) &&
+		(q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until))
+}
+
+func (q *LedgerQuery) BsonQuery() bson.M {
+	query := bson.M{}
+	if q.Username != "" {
+		query["username"] = q.Username
+	}
+	if q.CharName != "" {
+		query["charName"] = q.CharName
+	}
+	if q.Kind != "" {
+		query["kind"] = q.Kind
+	}
+	if q.Source != "" {
+		query["source"] = q.Source
+	}
+	if q.ItemBaseId != 0 {
+		query["itemBaseId"] = q.ItemBaseId
+	}
+	timeQuery := bson.M{}
+	if !q.Since.IsZero() {
+		timeQuery["$gte"] = q.Since
+	}
+	if !q.Until.IsZero() {
+		timeQuery["$lt"] = q.Until
+	}
+	if len(timeQuery) > 0 {
+		query["time"] = timeQuery
+	}
+	return query
+}
+
+type LedgerStats struct {
+	Recorded int64 `json:"recorded"`
+	Written  int64 `json:"written"`
+	Failed   int64 `json:"failed"`
+	// buffer was full, never written.
+	Dropped int64 `json:"dropped"`
+	Pending int   `json:"pending"`
+}
+
+// Ledger appends entries to storage in batches on its own goroutine.
+type Ledger struct {
+	db      Storage
+	configs *LedgerConfigs
+	logger  *log.Logger
+	entries chan *LedgerEntry
+	done    chan struct{}
+	mutex   sync.Mutex
+	stats   LedgerStats
+}
+
+func NewLedger(db Storage, configs *LedgerConfigs, logger *log.Logger) *Ledger {
+	return &Ledger{
+		db:      db,
+		configs: configs,
+		logger:  logger,
+		entries: make(chan *LedgerEntry, configs.BufferSize),
+		done:    make(chan struct{}),
+	}
+}
+
+func (l *Ledger) Run() {
+	db := l.db.Clone()
+	defer db.Close()
+	batch := make([]*LedgerEntry, 0, l.configs.BatchSize)
+	flushC := time.Tick(time.Duration(l.configs.FlushIntervalMillis) * time.Millisecond)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := db.AppendLedger(batch)
+		l.mutex.Lock()
+		if err != nil {
+			l.stats.Failed += int64(len(batch))
+		} else {
+			l.stats.Written += int64(len(batch))
+		}
+		l.mutex.Unlock()
+		if err != nil {
+			l.logger.Println("Ledger: lost", len(batch), "entries:", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case e, ok := <-l.entries:
+			if !ok {
+				flush()
+				close(l.done)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= l.configs.BatchSize {
+				flush()
+			}
+		case <-flushC:
+			flush()
+		}
+	}
+}
+
+// Record never blocks, it is called in world loop,
+// entry is dropped when buffer is full.
+func (l *Ledger) Record(e *LedgerEntry) {
+	if !l.configs.Enable {
+		return
+	}
+	e.Id = bson.NewObjectId()
+	e.Time = time.Now()
+	select {
+	case l.entries <- e:
+		l.mutex.Lock()
+		l.stats.Recorded += 1
+		l.mutex.Unlock()
+	default:
+		l.mutex.Lock()
+		l.stats.Dropped += 1
+		l.mutex.Unlock()
+		// keep it somewhere for audit.
+		l.logger.Println("Ledger: buffer full, dropped", e.Kind, e.Source,
+			e.Username, e.CharName, e.Amount, e.ItemBaseId, e.ItemInstanceId.Hex())
+	}
+}
+
+// Close writes buffered entries, no Record after it.
+func (l *Ledger) Close() {
+	close(l.entries)
+	<-l.done
+}
+
+func (l *Ledger) Stats() LedgerStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stats := l.stats
+	stats.Pending = len(l.entries)
+	return stats
+}
+
+func (c *Char) ledgerEntry(kind string, source string, amount int, detail string) *LedgerEntry {
+	e := &LedgerEntry{
+		Username: c.account.username,
+		CharId:   c.bsonId,
+		CharName: c.name,
+		Kind:     kind,
+		Source:   source,
+		Amount:   amount,
+		Balance:  c.dzeny,
+		Detail:   detail,
+	}
+	if c.scene != nil {
+		e.SceneName = c.scene.name
+	}
+	return e
+}
+
+// recordZeny should be called after dzeny changed.
+func (c *Char) recordZeny(source string, amount int, detail string) {
+	c.world.ledger.Record(c.ledgerEntry(LedgerZeny, source, amount, detail))
+}
+
+func (c *Char) recordItem(source string, item Itemer, amount int, detail string) {
+	e := c.ledgerEntry(LedgerItem, source, amount, detail)
+	e.ItemBaseId = item.BaseId()
+	e.ItemName = item.Name()
+	e.ItemInstanceId = item.InstanceId()
+	c.world.ledger.Record(e)
+}
+
+// GM helpers, call them from REPL.
+
+type LedgerSummary struct {
+	Username string `json:"username"`
+	CharName string `json:"charName"`
+	Source   string `json:"source"`
+	Gained   int    `json:"gained"`
+	Lost     int    `json:"lost"`
+	Count    int    `json:"count"`
+}
+
+// SummarizeLedger totals entries by char and source, most gained first.
+func SummarizeLedger(entries []*LedgerEntry, bySource bool) []*LedgerSummary {
+	sums := make(map[string]*LedgerSummary)
+	for _, e := range entries {
+		key := e.Username + "\x00" + e.CharName
+		source := ""
+		if bySource {
+			source = e.Source
+			key += "\x00" + source
+		}
+		s, ok := sums[key]
+		if !ok {
+			s = &LedgerSummary{
+				Username: e.Username,
+				CharName: e.CharName,
+				Source:   source,
+			}
+			sums[key] = s
+		}
+		if e.Amount > 0 {
+			s.Gained += e.Amount
+		} else {
+			s.Lost -= e.Amount
+		}
+		s.Count += 1
+	}
+	result := make([]*LedgerSummary, 0, len(sums))
+	for _, s := range sums {
+		result = append(result, s)
+	}
+	sort.Sort(ledgerSummaryByGained(result))
+	return result
+}
+
+type ledgerSummaryByGained []*LedgerSummary
+
+func (ls ledgerSummaryByGained) Len() int           { return len(ls) }
+func (ls ledgerSummaryByGained) Swap(i, j int)      { ls[i], ls[j] = ls[j], ls[i] }
+func (ls ledgerSummaryByGained) Less(i, j int) bool { return ls[i].Gained > ls[j].Gained }
+
+func (w *World) QueryLedger(q *LedgerQuery) []*LedgerEntry {
+	entries, err := w.db.FindLedger(q)
+	if err != nil {
+		w.logger.Println("QueryLedger:", err)
+		return nil
+	}
+	return entries
+}
+
+func (w *World) LedgerByChar(charName string, limit int) []*LedgerEntry {
+	return w.QueryLedger(&LedgerQuery{CharName: charName, Limit: limit})
+}
+
+func (w *World) LedgerByItem(baseId int, limit int) []*LedgerEntry {
+	return w.QueryLedger(&LedgerQuery{ItemBaseId: baseId, Limit: limit})
+}
+
+// LedgerTopEarners for gold farming reports.
+func (w *World) LedgerTopEarners(sinceMinutes int, limit int) []*LedgerSummary {
+	since := time.Now().Add(-time.Duration(sinceMinutes) * time.Minute)
+	entries := w.QueryLedger(&LedgerQuery{Kind: LedgerZeny, Since: since})
+	sums := SummarizeLedger(entries, false)
+	if limit > 0 && len(sums) > limit {
+		sums = sums[:limit]
+	}
+	return sums
+}
+
+// LedgerItemSources for duplication reports,
+// how every char got and lost the item.
+func (w *World) LedgerItemSources(baseId int, sinceMinutes int) []*LedgerSummary {
+	since := time.Now().Add(-time.Duration(sinceMinutes) * time.Minute)
+	entries := w.QueryLedger(&LedgerQuery{
+		Kind:       LedgerItem,
+		ItemBaseId: baseId,
+		Since:      since,
+	})
+	return SummarizeLedger(entries, true)
+}
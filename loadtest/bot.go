@@ -0,0 +1,309 @@
+package loadtest
+
+import (
+	"errors"
+	"github.com/XuHaoJun/dao"
+	"github.com/XuHaoJun/dao/daoclient"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	BehaviorWander = "wander"
+	BehaviorFight  = "fight"
+	BehaviorChat   = "chat"
+	BehaviorShop   = "shop"
+)
+
+// npc names in npc_db.go
+const (
+	teleportNpcName = "傳送師"
+	shopNpcName     = "Jack"
+	fieldSceneName  = "daoField01"
+	cityNpcOptTele  = 0
+	shopNpcOptShop  = 3
+)
+
+type botPosition struct {
+	X float32
+	Y float32
+}
+
+type bot struct {
+	index    int
+	behavior string
+	config   *Config
+	metrics  *Metrics
+	client   *daoclient.Client
+	rand     *rand.Rand
+	// what bot known about its scene
+	sceneName    string
+	id           int
+	pos          botPosition
+	npcs         map[string]int
+	mobs         map[int]botPosition
+	shop         *dao.ShopClient
+	items        map[string]map[string]*dao.ItemClient
+	lastTeleport time.Time
+}
+
+func newBot(index int, behavior string, config *Config, metrics *Metrics) *bot {
+	return &bot{
+		index:    index,
+		behavior: behavior,
+		config:   config,
+		metrics:  metrics,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano() + int64(index))),
+		npcs:     make(map[string]int),
+		mobs:     make(map[int]botPosition),
+		items:    make(map[string]map[string]*dao.ItemClient),
+	}
+}
+
+func (b *bot) username() string {
+	return b.config.Prefix + strconv.Itoa(b.index)
+}
+
+func (b *bot) run(quit chan struct{}) {
+	options := daoclient.DefaultOptions()
+	options.Codec = b.config.Codec
+	client, err := daoclient.Dial(b.config.URL, options)
+	if err != nil {
+		b.metrics.inc(&b.metrics.ConnectFails)
+		return
+	}
+	b.client = client
+	b.metrics.inc(&b.metrics.Connected)
+	defer client.Close()
+	err = b.enterWorld()
+	if err != nil {
+		b.metrics.inc(&b.metrics.ConnectFails)
+		return
+	}
+	b.metrics.inc(&b.metrics.Ready)
+	action := time.NewTicker(b.config.ActionInterval)
+	defer action.Stop()
+	ping := time.NewTicker(b.config.PingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-client.Events:
+			if !ok {
+				b.metrics.inc(&b.metrics.Disconnects)
+				return
+			}
+			b.onEvent(e)
+		case <-action.C:
+			b.act()
+		case <-ping.C:
+			client.Ping()
+		case <-quit:
+			client.Logout()
+			return
+		}
+	}
+}
+
+func (b *bot) waitFor(method string) (*daoclient.Event, error) {
+	return b.client.WaitFor(method, b.config.SetupTimeout)
+}
+
+// register, login and use first char, create it when not found.
+func (b *bot) enterWorld() error {
+	c := b.client
+	name := b.username()
+	c.RegisterAccount(name, b.config.Password, name+"@loadtest")
+	// duplicated account is fine, it ran before.
+	time.Sleep(b.config.SetupDelay)
+	c.LoginAccount(name, b.config.Password)
+	e, err := b.waitFor("handleSuccessLoginAcccount")
+	if err != nil {
+		return err
+	}
+	login, ok := e.Payload.(*daoclient.LoginAccountEvent)
+	if !ok {
+		return errors.New("loadtest: bad login payload")
+	}
+	if len(login.CharConfigs) == 0 {
+		c.CreateChar(name)
+		_, err = b.waitFor("handleSuccessCreateChar")
+		if err != nil {
+			return err
+		}
+	}
+	c.LoginChar(0)
+	e, err = b.waitFor("handleJoinScene")
+	if err != nil {
+		return err
+	}
+	b.onEvent(e)
+	return nil
+}
+
+func (b *bot) onEvent(e *daoclient.Event) {
+	switch p := e.Payload.(type) {
+	case *dao.ClientCallError:
+		b.metrics.inc(&b.metrics.CallErrors)
+	case *daoclient.PongEvent:
+		b.metrics.AddLatency(p.RTT)
+	case *daoclient.JoinSceneEvent:
+		b.sceneName = p.SceneName
+		b.id = p.Id
+		b.npcs = make(map[string]int)
+		b.mobs = make(map[int]botPosition)
+	case *daoclient.PositionEvent:
+		b.pos = botPosition{p.X, p.Y}
+	case *dao.NpcClientBasic:
+		if p.BioClient != nil {
+			b.npcs[p.BioClient.Name] = p.BioClient.Id
+		}
+	case *dao.MobClientBasic:
+		if p.BioClient != nil && p.BioClient.CpBody != nil &&
+			p.BioClient.CpBody.Position != nil {
+			pos := p.BioClient.CpBody.Position
+			b.mobs[p.BioClient.Id] = botPosition{float32(pos.X), float32(pos.Y)}
+		}
+	case *daoclient.RemoveByIdEvent:
+		delete(b.mobs, p.Id)
+	case *dao.ViewSnapshotDeltaClient:
+		for _, entity := range p.Entities {
+			b.updateEntity(entity)
+		}
+		for _, id := range p.Removed {
+			delete(b.mobs, id)
+		}
+	case *dao.ShopClient:
+		b.shop = p
+	case *daoclient.ItemsEvent:
+		b.updateItems(p)
+	}
+}
+
+func (b *bot) updateEntity(entity *dao.ViewSnapshotEntityClient) {
+	pos, isMob := b.mobs[entity.Id]
+	if entity.Id == b.id {
+		pos = b.pos
+	} else if !isMob {
+		return
+	}
+	if entity.X != nil {
+		pos.X = *entity.X
+	}
+	if entity.Y != nil {
+		pos.Y = *entity.Y
+	}
+	if entity.Id == b.id {
+		b.pos = pos
+	} else {
+		b.mobs[entity.Id] = pos
+	}
+}
+
+func (b *bot) updateItems(e *daoclient.ItemsEvent) {
+	for iType, slots := range e.Items {
+		known, ok := b.items[iType]
+		if !ok {
+			known = make(map[string]*dao.ItemClient)
+			b.items[iType] = known
+		}
+		for slot, item := range slots {
+			if item == nil {
+				delete(known, slot)
+				continue
+			}
+			if e.IsMerge {
+				if old, ok := known[slot]; ok && item.BaseId == 0 {
+					item.BaseId = old.BaseId
+				}
+			}
+			known[slot] = item
+		}
+	}
+}
+
+func (b *bot) act() {
+	switch b.behavior {
+	case BehaviorWander:
+		if b.goField() {
+			b.wander()
+		}
+	case BehaviorFight:
+		if b.goField() {
+			b.fight()
+		}
+	case BehaviorChat:
+		b.client.TalkScene(b.config.ChatMessage + " " + strconv.Itoa(b.rand.Intn(10000)))
+	case BehaviorShop:
+		b.trade()
+	}
+}
+
+// teleport by npc, return true when in field.
+func (b *bot) goField() bool {
+	if b.sceneName == fieldSceneName {
+		return true
+	}
+	nid, ok := b.npcs[teleportNpcName]
+	if !ok || time.Since(b.lastTeleport) < 5*time.Second {
+		return false
+	}
+	b.lastTeleport = time.Now()
+	b.client.TalkNpcById(nid)
+	b.client.ResponseTalkingNpc(cityNpcOptTele)
+	return false
+}
+
+func (b *bot) wander() {
+	r := b.config.WanderRadius
+	x := b.pos.X + (b.rand.Float32()*2-1)*r
+	y := b.pos.Y + (b.rand.Float32()*2-1)*r
+	b.client.Move(x, y)
+}
+
+func (b *bot) fight() {
+	found := false
+	var targetPos botPosition
+	best := float32(math.MaxFloat32)
+	for _, pos := range b.mobs {
+		dx, dy := pos.X-b.pos.X, pos.Y-b.pos.Y
+		d := dx*dx + dy*dy
+		if d < best {
+			best, targetPos, found = d, pos, true
+		}
+	}
+	if !found {
+		b.wander()
+		return
+	}
+	b.client.Move(targetPos.X, targetPos.Y)
+	b.client.UseSkillByBaseId(1 + b.rand.Intn(2))
+}
+
+// buy one random shop item then sell something back.
+func (b *bot) trade() {
+	if b.shop == nil {
+		nid, ok := b.npcs[shopNpcName]
+		if !ok {
+			return
+		}
+		b.client.TalkNpcById(nid)
+		b.client.ResponseTalkingNpc(shopNpcOptShop)
+		return
+	}
+	if len(b.shop.Items) > 0 && b.rand.Intn(2) == 0 {
+		b.client.BuyItemFromOpeningShop(b.rand.Intn(len(b.shop.Items)))
+		return
+	}
+	for _, slots := range b.items {
+		for slot, item := range slots {
+			slotIndex, err := strconv.Atoi(slot)
+			if err != nil || item.BaseId == 0 {
+				continue
+			}
+			b.client.SellItemToOpeningShop(item.BaseId, slotIndex)
+			return
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Package loadtest drives many simulated players against a dao
+// server, run it by `dao loadtest`.
+package loadtest
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/XuHaoJun/dao"
+	"github.com/XuHaoJun/dao/daoclient"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	URL string
+	// empty means derive from URL.
+	StatsURL  string
+	Players   int
+	SpawnRate float64
+	Duration  time.Duration
+	// like "wander=2,fight=1,chat=1,shop=1"
+	Behaviors      map[string]int
+	Prefix         string
+	Password       string
+	Codec          string
+	ActionInterval time.Duration
+	PingInterval   time.Duration
+	ReportInterval time.Duration
+	SetupTimeout   time.Duration
+	SetupDelay     time.Duration
+	WanderRadius   float32
+	ChatMessage    string
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		URL:            "ws://127.0.0.1:3000/daows",
+		Players:        100,
+		SpawnRate:      20,
+		Duration:       time.Minute,
+		Behaviors:      map[string]int{BehaviorWander: 1},
+		Prefix:         "loadtest",
+		Password:       "loadtest",
+		Codec:          dao.JSONClientCallCodecName,
+		ActionInterval: time.Second,
+		PingInterval:   2 * time.Second,
+		ReportInterval: 10 * time.Second,
+		SetupTimeout:   30 * time.Second,
+		SetupDelay:     500 * time.Millisecond,
+		WanderRadius:   400,
+		ChatMessage:    "hello from loadtest",
+	}
+}
+
+func ParseBehaviors(s string) (map[string]int, error) {
+	behaviors := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		weight := 1
+		if len(kv) == 2 {
+			w, err := strconv.Atoi(kv[1])
+			if err != nil || w < 0 {
+				return nil, errors.New("loadtest: bad behavior weight " + part)
+			}
+			weight = w
+		}
+		switch kv[0] {
+		case BehaviorWander, BehaviorFight, BehaviorChat, BehaviorShop:
+			behaviors[kv[0]] = weight
+		default:
+			return nil, errors.New("loadtest: unknown behavior " + kv[0])
+		}
+	}
+	if len(behaviors) == 0 {
+		return nil, errors.New("loadtest: no behavior")
+	}
+	return behaviors, nil
+}
+
+// behavior of i-th player, players split by weight.
+func (config *Config) behaviorOf(i int) string {
+	names := make([]string, 0, len(config.Behaviors))
+	total := 0
+	for name, w := range config.Behaviors {
+		names = append(names, name)
+		total += w
+	}
+	sort.Strings(names)
+	if total == 0 {
+		return BehaviorWander
+	}
+	n := i % total
+	for _, name := range names {
+		n -= config.Behaviors[name]
+		if n < 0 {
+			return name
+		}
+	}
+	return BehaviorWander
+}
+
+func (config *Config) statsURL() (string, error) {
+	if config.StatsURL != "" {
+		return config.StatsURL, nil
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "wss":
+		u.Scheme = "https"
+	default:
+		u.Scheme = "http"
+	}
+	u.Path = "/serverStats"
+	return u.String(), nil
+}
+
+func FetchServerStats(statsURL string) (*dao.ServerStats, error) {
+	resp, err := http.Get(statsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	stats := &dao.ServerStats{}
+	err = json.NewDecoder(resp.Body).Decode(stats)
+	return stats, err
+}
+
+type Report struct {
+	Elapsed      time.Duration
+	Players      int
+	Connected    int64
+	Ready        int64
+	ConnectFails int64
+	Disconnects  int64
+	CallErrors   int64
+	Traffic      daoclient.ClientStats
+	Latency      LatencyReport
+	// nil when server stats can not be fetched.
+	ServerBefore *dao.ServerStats
+	ServerAfter  *dao.ServerStats
+}
+
+func (r *Report) rate(n int64) float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / r.Elapsed.Seconds()
+}
+
+func (r *Report) WriteTo(out io.Writer) {
+	fmt.Fprintf(out, "elapsed: %v players: %d connected: %d ready: %d connectFails: %d disconnects: %d callErrors: %d\n",
+		r.Elapsed, r.Players, r.Connected, r.Ready, r.ConnectFails, r.Disconnects, r.CallErrors)
+	fmt.Fprintf(out, "sent: %.1f calls/s received: %.1f calls/s %.1f frames/s %.1f KB/s\n",
+		r.rate(r.Traffic.SentCalls), r.rate(r.Traffic.ReceivedCalls),
+		r.rate(r.Traffic.ReceivedFrames), r.rate(r.Traffic.ReceivedBytes)/1024)
+	l := r.Latency
+	fmt.Fprintf(out, "latency: samples: %d p50: %v p90: %v p99: %v max: %v\n",
+		l.Samples, l.P50, l.P90, l.P99, l.Max)
+	if r.ServerBefore == nil || r.ServerAfter == nil ||
+		r.ServerBefore.WorldTick == nil || r.ServerAfter.WorldTick == nil {
+		fmt.Fprintln(out, "server: stats unavailable")
+		return
+	}
+	before, after := r.ServerBefore.WorldTick, r.ServerAfter.WorldTick
+	ticks := after.Ticks - before.Ticks
+	avg := 0.0
+	if ticks > 0 {
+		avg = (after.TotalMs - before.TotalMs) / float64(ticks)
+	}
+	fmt.Fprintf(out, "server tick: before avg: %.3fms during avg: %.3fms recent p99: %.3fms recent max: %.3fms overBudget: %d (budget %.3fms)\n",
+		before.RecentAvgMs, avg, after.RecentP99Ms, after.RecentMaxMs,
+		after.OverBudget-before.OverBudget, after.BudgetMs)
+	hubBefore, hubAfter := r.ServerBefore.WsHub, r.ServerAfter.WsHub
+	fmt.Fprintf(out, "server hub: connections: %d slowClientDisconnects: %d droppedCalls: %d coalescedCalls: %d\n",
+		hubAfter.Connections,
+		hubAfter.SlowClientDisconnects-hubBefore.SlowClientDisconnects,
+		hubAfter.DroppedCalls-hubBefore.DroppedCalls,
+		hubAfter.CoalescedCalls-hubBefore.CoalescedCalls)
+	if r.ServerBefore.RateLimit != nil && r.ServerAfter.RateLimit != nil {
+		fmt.Fprintf(out, "server rateLimit: dropped: %d kicked: %d\n",
+			r.ServerAfter.RateLimit.Dropped-r.ServerBefore.RateLimit.Dropped,
+			r.ServerAfter.RateLimit.Kicked-r.ServerBefore.RateLimit.Kicked)
+	}
+}
+
+type runner struct {
+	config  *Config
+	metrics *Metrics
+	bots    []*bot
+	mutex   sync.Mutex
+	start   time.Time
+}
+
+func (rn *runner) traffic() daoclient.ClientStats {
+	rn.mutex.Lock()
+	defer rn.mutex.Unlock()
+	total := daoclient.ClientStats{}
+	for _, b := range rn.bots {
+		if b.client == nil {
+			continue
+		}
+		s := b.client.Stats()
+		total.SentCalls += s.SentCalls
+		total.ReceivedFrames += s.ReceivedFrames
+		total.ReceivedCalls += s.ReceivedCalls
+		total.ReceivedBytes += s.ReceivedBytes
+	}
+	return total
+}
+
+func (rn *runner) report() *Report {
+	m := rn.metrics
+	return &Report{
+		Elapsed:      time.Since(rn.start),
+		Players:      rn.config.Players,
+		Connected:    atomic.LoadInt64(&m.Connected),
+		Ready:        atomic.LoadInt64(&m.Ready),
+		ConnectFails: atomic.LoadInt64(&m.ConnectFails),
+		Disconnects:  atomic.LoadInt64(&m.Disconnects),
+		CallErrors:   atomic.LoadInt64(&m.CallErrors),
+		Traffic:      rn.traffic(),
+		Latency:      m.Latency(),
+	}
+}
+
+// Run spawn players, wait Duration then logout all of them.
+// progress reports are written to out.
+func Run(config *Config, out io.Writer) (*Report, error) {
+	statsURL, err := config.statsURL()
+	if err != nil {
+		return nil, err
+	}
+	rn := &runner{
+		config:  config,
+		metrics: NewMetrics(),
+		bots:    make([]*bot, 0, config.Players),
+		start:   time.Now(),
+	}
+	before, err := FetchServerStats(statsURL)
+	if err != nil {
+		fmt.Fprintln(out, "loadtest: can not fetch server stats:", err)
+	}
+	quit := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	spawnEvery := time.Duration(float64(time.Second) / config.SpawnRate)
+	stop := time.After(config.Duration)
+	reportTicker := time.NewTicker(config.ReportInterval)
+	defer reportTicker.Stop()
+	spawnTicker := time.NewTicker(spawnEvery)
+	defer spawnTicker.Stop()
+	spawned := 0
+loop:
+	for {
+		select {
+		case <-spawnTicker.C:
+			if spawned >= config.Players {
+				continue
+			}
+			b := newBot(spawned, config.behaviorOf(spawned), config, rn.metrics)
+			rn.mutex.Lock()
+			rn.bots = append(rn.bots, b)
+			rn.mutex.Unlock()
+			spawned += 1
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.run(quit)
+			}()
+		case <-reportTicker.C:
+			r := rn.report()
+			fmt.Fprintf(out, "[%v] spawned: %d ready: %d disconnects: %d latency p99: %v\n",
+				r.Elapsed/time.Second*time.Second, spawned, r.Ready, r.Disconnects, r.Latency.P99)
+		case <-stop:
+			break loop
+		}
+	}
+	// before logout, so tick stats are under load.
+	after, afterErr := FetchServerStats(statsURL)
+	report := rn.report()
+	close(quit)
+	wg.Wait()
+	if err == nil && afterErr == nil {
+		report.ServerBefore = before
+		report.ServerAfter = after
+	}
+	return report, nil
+}
+
+// Main is `dao loadtest [flags]`.
+func Main(args []string) int {
+	config := DefaultConfig()
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.StringVar(&config.URL, "url", config.URL, "Websocket url of server.")
+	fs.StringVar(&config.StatsURL, "statsURL", "", "Server stats url, default from url.")
+	fs.IntVar(&config.Players, "players", config.Players, "Number of simulated players.")
+	fs.Float64Var(&config.SpawnRate, "spawnRate", config.SpawnRate, "Players spawned per second.")
+	fs.DurationVar(&config.Duration, "duration", config.Duration, "Test duration.")
+	behaviors := fs.String("behaviors", "wander=1", "Behavior weights: wander, fight, chat, shop.")
+	fs.StringVar(&config.Prefix, "prefix", config.Prefix, "Account username prefix.")
+	fs.StringVar(&config.Password, "password", config.Password, "Account password.")
+	fs.StringVar(&config.Codec, "codec", config.Codec, "dao.json or dao.msgpack.")
+	fs.DurationVar(&config.ActionInterval, "actionInterval", config.ActionInterval, "Time between player actions.")
+	fs.DurationVar(&config.PingInterval, "pingInterval", config.PingInterval, "Time between latency pings.")
+	fs.DurationVar(&config.ReportInterval, "reportInterval", config.ReportInterval, "Time between progress reports.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	var err error
+	config.Behaviors, err = ParseBehaviors(*behaviors)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if config.Players <= 0 || config.SpawnRate <= 0 {
+		fmt.Fprintln(os.Stderr, "loadtest: players and spawnRate must be positive")
+		return 2
+	}
+	report, err := Run(config, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	report.WriteTo(os.Stdout)
+	return 0
+}
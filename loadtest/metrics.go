@@ -0,0 +1,86 @@
+package loadtest
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keep memory fixed however long test run.
+const maxLatencySamples = 100000
+
+type Metrics struct {
+	Connected    int64
+	ConnectFails int64
+	Ready        int64
+	Disconnects  int64
+	CallErrors   int64
+	//
+	latencyMutex sync.Mutex
+	latencies    []time.Duration
+	latencyCount int64
+	rand         *rand.Rand
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		latencies: make([]time.Duration, 0, 1024),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (m *Metrics) inc(n *int64) {
+	atomic.AddInt64(n, 1)
+}
+
+// reservoir sampling, all samples have same chance to be kept.
+func (m *Metrics) AddLatency(d time.Duration) {
+	m.latencyMutex.Lock()
+	defer m.latencyMutex.Unlock()
+	m.latencyCount += 1
+	if len(m.latencies) < maxLatencySamples {
+		m.latencies = append(m.latencies, d)
+		return
+	}
+	i := m.rand.Int63n(m.latencyCount)
+	if i < maxLatencySamples {
+		m.latencies[i] = d
+	}
+}
+
+type LatencyReport struct {
+	Samples int64
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+func (m *Metrics) Latency() LatencyReport {
+	m.latencyMutex.Lock()
+	samples := make([]time.Duration, len(m.latencies))
+	copy(samples, m.latencies)
+	count := m.latencyCount
+	m.latencyMutex.Unlock()
+	r := LatencyReport{Samples: count}
+	if len(samples) == 0 {
+		return r
+	}
+	sort.Sort(durations(samples))
+	at := func(p int) time.Duration {
+		return samples[(len(samples)-1)*p/100]
+	}
+	r.P50 = at(50)
+	r.P90 = at(90)
+	r.P99 = at(99)
+	r.Max = samples[len(samples)-1]
+	return r
+}
+
+type durations []time.Duration
+
+func (p durations) Len() int           { return len(p) }
+func (p durations) Less(i, j int) bool { return p[i] < p[j] }
+func (p durations) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,47 @@
+package dao
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// cmdFlags adds flags every storage command has.
+func cmdFlags(fs *flag.FlagSet) (configDir *string, backend *string) {
+	configDir = fs.String("configDir", "./", "Dao Configuraiton dir.")
+	backend = fs.String("storage", "", "Storage backend, mongo, bolt or memory.")
+	return
+}
+
+func cmdStorage(configDir string, backend string) (*DaoConfigs, Storage, error) {
+	configs := NewDaoConfigs(configDir)
+	configs.LoadConfigFiles()
+	if backend != "" {
+		configs.StorageConfigs.Backend = backend
+	}
+	db, err := NewStorage(configs)
+	return configs, db, err
+}
+
+// MigrateMain runs "dao migrate", server should be stopped.
+func MigrateMain(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configDir, backend := cmdFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	_, db, err := cmdStorage(*configDir, *backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	result, err := MigrateStorage(db)
+	fmt.Printf("migrate to schema version %d: scanned %d, migrated %d accounts\n",
+		CurrentSchemaVersion(), result.Scanned, result.Migrated)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
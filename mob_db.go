@@ -48,11 +48,11 @@ func newMobByBaseId1(w *World, id int) Mober {
 						Params: []interface{}{
 							mob.id,
 							map[string]float32{
-								"angle": float32(mob.SceneObject.body.Angle()),
+								"angle": newAngle,
 							},
 						},
 					}
-					mob.PublishClientCall(clientCall)
+					mob.PublishLegacyClientCall(clientCall)
 				}
 				mob.UseFireBall()
 				return
@@ -0,0 +1,273 @@
+package dao
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"sync"
+	"time"
+)
+
+// persistJob is all pending writes of one account,
+// a later account dump replaces earlier char dumps.
+type persistJob struct {
+	username  string
+	accountId bson.ObjectId
+	account   *AccountDumpDB
+	chars     map[int]*CharDumpDB
+	snapshots []*persistSnapshot
+}
+
+type persistSnapshot struct {
+	snap *CharSnapshot
+	keep int
+}
+
+type PersistQueueStats struct {
+	Depth     int   `json:"depth"`
+	InFlight  int   `json:"inFlight"`
+	Enqueued  int64 `json:"enqueued"`
+	Coalesced int64 `json:"coalesced"`
+	Saved     int64 `json:"saved"`
+	Retries   int64 `json:"retries"`
+	Failed    int64 `json:"failed"`
+}
+
+// PersistQueue writes saves behind the world loop with bounded workers.
+// One account is written by at most one worker at a time.
+type PersistQueue struct {
+	db      Storage
+	configs *PersistConfigs
+	logger  *log.Logger
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	// keyed by username
+	pending  map[string]*persistJob
+	order    []string
+	inFlight map[string]bool
+	closed   bool
+	started  bool
+	workerWg sync.WaitGroup
+	stats    PersistQueueStats
+}
+
+func NewPersistQueue(db Storage, configs *PersistConfigs, logger *log.Logger) *PersistQueue {
+	q := &PersistQueue{
+		db:       db,
+		configs:  configs,
+		logger:   logger,
+		pending:  make(map[string]*persistJob),
+		order:    make([]string, 0, 64),
+		inFlight: make(map[string]bool),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+func (q *PersistQueue) Start() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.started {
+		return
+	}
+	q.started = true
+	workers := q.configs.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	q.workerWg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.worker(q.db.Clone())
+	}
+}
+
+// job returns pending job of username, creates it if need.
+// caller must hold mutex.
+func (q *PersistQueue) job(username string, accountId bson.ObjectId) *persistJob {
+	q.stats.Enqueued += 1
+	job, ok := q.pending[username]
+	if ok {
+		q.stats.Coalesced += 1
+		return job
+	}
+	job = &persistJob{
+		username:  username,
+		accountId: accountId,
+		chars:     make(map[int]*CharDumpDB),
+	}
+	q.pending[username] = job
+	q.order = append(q.order, username)
+	return job
+}
+
+func (q *PersistQueue) SaveAccount(dump *AccountDumpDB) {
+	q.mutex.Lock()
+	job := q.job(dump.Username, dump.Id)
+	job.account = dump
+	job.chars = make(map[int]*CharDumpDB)
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *PersistQueue) SaveChar(username string, accountId bson.ObjectId, slotIndex int, dump *CharDumpDB) {
+	q.mutex.Lock()
+	job := q.job(username, accountId)
+	if job.account != nil {
+		chars := make([]*CharDumpDB, len(job.account.Chars))
+		copy(chars, job.account.Chars)
+		for len(chars) <= slotIndex {
+			chars = append(chars, nil)
+		}
+		chars[slotIndex] = dump
+		accDump := *job.account
+		accDump.Chars = chars
+		job.account = &accDump
+	} else {
+		job.chars[slotIndex] = dump
+	}
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *PersistQueue) SaveCharSnapshot(username string, accountId bson.ObjectId, snap *CharSnapshot, keep int) {
+	q.mutex.Lock()
+	job := q.job(username, accountId)
+	job.snapshots = append(job.snapshots, &persistSnapshot{snap, keep})
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+}
+
+// next pops first pending job not in flight.
+// caller must hold mutex.
+func (q *PersistQueue) next() *persistJob {
+	for i, username := range q.order {
+		if q.inFlight[username] {
+			continue
+		}
+		job := q.pending[username]
+		delete(q.pending, username)
+		q.order = append(q.order[:i], q.order[i+1:]...)
+		q.inFlight[username] = true
+		return job
+	}
+	return nil
+}
+
+func (q *PersistQueue) worker(db Storage) {
+	defer q.workerWg.Done()
+	defer db.Close()
+	for {
+		q.mutex.Lock()
+		job := q.next()
+		for job == nil {
+			if q.closed && len(q.pending) == 0 {
+				q.mutex.Unlock()
+				return
+			}
+			q.cond.Wait()
+			job = q.next()
+		}
+		q.mutex.Unlock()
+		err := q.write(db, job)
+		q.mutex.Lock()
+		delete(q.inFlight, job.username)
+		if err != nil {
+			q.stats.Failed += 1
+		} else {
+			q.stats.Saved += 1
+		}
+		q.mutex.Unlock()
+		q.cond.Broadcast()
+		if err != nil {
+			q.logger.Println("PersistQueue: give up saving account:",
+				job.username, err)
+		}
+	}
+}
+
+func (q *PersistQueue) write(db Storage, job *persistJob) (err error) {
+	for attempt := 0; ; attempt++ {
+		err = q.writeOnce(db, job)
+		// not found never success on retry.
+		if err == nil || err == ErrStorageNotFound ||
+			attempt >= q.configs.MaxRetries {
+			return
+		}
+		q.mutex.Lock()
+		q.stats.Retries += 1
+		q.mutex.Unlock()
+		delay := time.Duration(q.configs.RetryDelayMillis) * time.Millisecond
+		time.Sleep(delay * time.Duration(attempt+1))
+	}
+}
+
+func (q *PersistQueue) writeOnce(db Storage, job *persistJob) (err error) {
+	defer func() {
+		// mgo may panic on broken session.
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	if job.account != nil {
+		if err = db.SaveAccount(job.account); err != nil {
+			return
+		}
+		job.account = nil
+	}
+	// don't write them again on retry.
+	for slotIndex, dump := range job.chars {
+		err = db.SaveChar(job.accountId, slotIndex, dump)
+		if err != nil {
+			return
+		}
+		delete(job.chars, slotIndex)
+	}
+	for len(job.snapshots) > 0 {
+		s := job.snapshots[0]
+		if err = db.SaveCharSnapshot(s.snap, s.keep); err != nil {
+			return
+		}
+		job.snapshots = job.snapshots[1:]
+	}
+	return
+}
+
+// Wait blocks until nothing of username is pending or in flight,
+// so a following read sees the latest save.
+func (q *PersistQueue) Wait(username string) {
+	q.mutex.Lock()
+	for q.started && (q.pending[username] != nil || q.inFlight[username]) {
+		q.cond.Wait()
+	}
+	q.mutex.Unlock()
+}
+
+// Flush blocks until every save enqueued before it is written or given up.
+func (q *PersistQueue) Flush() {
+	q.mutex.Lock()
+	for q.started && (len(q.pending) > 0 || len(q.inFlight) > 0) {
+		q.cond.Wait()
+	}
+	q.mutex.Unlock()
+}
+
+// Close flushes the queue and stops workers.
+func (q *PersistQueue) Close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+	q.workerWg.Wait()
+}
+
+func (q *PersistQueue) Stats() PersistQueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	stats := q.stats
+	stats.Depth = len(q.pending)
+	stats.InFlight = len(q.inFlight)
+	return stats
+}
@@ -0,0 +1,200 @@
+package dao
+
+import (
+	"github.com/xuhaojun/chipmunk"
+	"github.com/xuhaojun/chipmunk/vect"
+	"strconv"
+	"time"
+)
+
+// used when ScenePortalDef.CooldownSeconds is zero.
+const DefaultPortalCooldown = 3 * time.Second
+
+// Portal is a sensor area moves chars touched it to target scene.
+// Collision happens on scene worker, so it only queues the char,
+// World teleports queued ones after all scenes updated.
+type Portal struct {
+	*SceneObject
+	name        string
+	width       float32
+	height      float32
+	targetScene string
+	targetSpawn string
+	bodyViewId  int
+	// requirements, zero ones not checked.
+	minLevel      int
+	questId       int
+	questComplete bool
+	requireParty  bool
+	cooldown      time.Duration
+}
+
+type PortalClient struct {
+	Id          int           `json:"id"`
+	Name        string        `json:"name"`
+	TargetScene string        `json:"targetScene"`
+	CpBody      *CpBodyClient `json:"cpBody"`
+	BodyViewId  int           `json:"bodyViewId"`
+}
+
+func NewPortal(def *ScenePortalDef) *Portal {
+	p := &Portal{
+		SceneObject:   &SceneObject{},
+		name:          def.Name,
+		width:         def.Width,
+		height:        def.Height,
+		targetScene:   def.TargetScene,
+		targetSpawn:   def.TargetSpawn,
+		bodyViewId:    9000,
+		minLevel:      def.MinLevel,
+		questId:       def.QuestId,
+		questComplete: def.QuestComplete,
+		requireParty:  def.RequireParty,
+		cooldown:      time.Duration(def.CooldownSeconds) * time.Second,
+	}
+	if p.cooldown == 0 {
+		p.cooldown = DefaultPortalCooldown
+	}
+	shape := chipmunk.NewBox(vect.Vector_Zero, vect.Float(p.width), vect.Float(p.height))
+	shape.Layer = CharLayer
+	shape.IsSensor = true
+	body := chipmunk.NewBody(1, 1)
+	body.IgnoreGravity = true
+	body.SetMoment(chipmunk.Inf)
+	body.SetPosition(vect.Vect{X: vect.Float(def.X), Y: vect.Float(def.Y)})
+	body.AddShape(shape)
+	body.UserData = p
+	body.CallbackHandler = p
+	p.body = body
+	return p
+}
+
+func (p *Portal) Name() string {
+	return p.name
+}
+
+func (p *Portal) SceneObjecter() SceneObjecter {
+	return p
+}
+
+func (p *Portal) Client() *PortalClient {
+	return &PortalClient{
+		Id:          p.id,
+		Name:        p.name,
+		TargetScene: p.targetScene,
+		CpBody:      ToCpBodyClient(p.body),
+		BodyViewId:  p.bodyViewId,
+	}
+}
+
+// denyReason returns why c can't use portal now, empty if it can,
+// silent true if c should not be told.
+func (p *Portal) denyReason(c Charer, now time.Time) (reason string, silent bool) {
+	// just came from another portal, maybe standing on its way back.
+	if now.Sub(c.LastPortalTime()) < p.cooldown {
+		return "cooldown", true
+	}
+	if p.minLevel > 0 && c.Level() < p.minLevel {
+		return "Need level " + strconv.Itoa(p.minLevel) + " to pass!", false
+	}
+	if p.questId > 0 {
+		quest, ok := c.FindQuest(p.questId)
+		if !ok {
+			return "Need quest " + strconv.Itoa(p.questId) + " to pass!", false
+		}
+		if p.questComplete && !quest.IsComplete() {
+			return "Complete quest " + strconv.Itoa(p.questId) + " to pass!", false
+		}
+	}
+	if p.requireParty && c.Party() == nil {
+		return "Need a party to pass!", false
+	}
+	return "", false
+}
+
+// Teleport moves c to target if it meets requirements,
+// call it on world loop.
+func (p *Portal) Teleport(c Charer) {
+	if p.scene == nil || c.Scene() != p.scene {
+		return
+	}
+	now := time.Now()
+	if reason, silent := p.denyReason(c, now); reason != "" {
+		if !silent {
+			c.SendChatMessage("System", p.name, reason)
+		}
+		return
+	}
+	target := p.scene.world.FindSceneByName(p.targetScene)
+	if target == nil {
+		return
+	}
+	sp := target.SpawnPoint(p.targetSpawn)
+	if sp == nil {
+		sp = target.DefaultSpawnPoint()
+	}
+	c.SetLastPortalTime(now)
+	c.TeleportBySceneName(target.name, sp.X, sp.Y)
+}
+
+func (p *Portal) onCharEnter(c Charer) {
+	p.scene.portalQueue = append(p.scene.portalQueue, &portalEnter{p, c})
+}
+
+func (p *Portal) CollisionEnter(arbiter *chipmunk.Arbiter) bool {
+	if p.scene == nil {
+		return false
+	}
+	c, ok := arbiter.BodyA.UserData.(Charer)
+	if ok {
+		p.onCharEnter(c)
+	}
+	c, ok = arbiter.BodyB.UserData.(Charer)
+	if ok {
+		p.onCharEnter(c)
+	}
+	return false
+}
+
+func (p *Portal) CollisionExit(arbiter *chipmunk.Arbiter) {
+}
+
+func (p *Portal) CollisionPreSolve(arbiter *chipmunk.Arbiter) bool {
+	return false
+}
+
+func (p *Portal) CollisionPostSolve(arbiter *chipmunk.Arbiter) {}
+
+type portalEnter struct {
+	portal *Portal
+	char   Charer
+}
+
+// runPortals teleports chars entered portals in last update,
+// call it on world loop.
+func (s *Scene) runPortals() {
+	if len(s.portalQueue) == 0 {
+		return
+	}
+	queue := s.portalQueue
+	s.portalQueue = nil
+	for _, enter := range queue {
+		enter.portal.Teleport(enter.char)
+	}
+}
+
+// placeDefPortals replaces portals placed by def.
+func (s *Scene) placeDefPortals() {
+	for _, p := range s.defPortals {
+		s.Remove(p.SceneObjecter())
+	}
+	s.defPortals = nil
+	if s.def == nil {
+		return
+	}
+	for _, portalDef := range s.def.Portals {
+		p := NewPortal(portalDef)
+		s.Add(p.SceneObjecter())
+		s.defPortals = append(s.defPortals, p)
+	}
+}
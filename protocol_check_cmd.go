@@ -0,0 +1,91 @@
+package dao
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// http responses shaped like client calls, never sent over websocket.
+var webOnlyServerCallMethods = map[string]struct{}{
+	"handleWebAccountInfo": struct{}{},
+}
+
+// UnlistedServerCalls finds Method: "handle..." literals in
+// dir's go files without a serverCalls entry.
+func UnlistedServerCalls(dir string) ([]string, error) {
+	listed := make(map[string]struct{}, len(serverCalls))
+	for _, sc := range serverCalls {
+		listed[sc.method] = struct{}{}
+	}
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	unlisted := make([]string, 0)
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				kv, ok := n.(*ast.KeyValueExpr)
+				if !ok {
+					return true
+				}
+				key, ok := kv.Key.(*ast.Ident)
+				if !ok || key.Name != "Method" {
+					return true
+				}
+				lit, ok := kv.Value.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					return true
+				}
+				method, err := strconv.Unquote(lit.Value)
+				if err != nil || !strings.HasPrefix(method, "handle") {
+					return true
+				}
+				if _, ok := listed[method]; ok {
+					return true
+				}
+				if _, ok := webOnlyServerCallMethods[method]; ok {
+					return true
+				}
+				unlisted = append(unlisted,
+					fmt.Sprintf("%s: %s", fset.Position(lit.Pos()), method))
+				return true
+			})
+		}
+	}
+	sort.Strings(unlisted)
+	return unlisted, nil
+}
+
+// ProtocolCheckMain runs "dao protocol-check", fails when
+// server sends a call protocol schema does not list.
+func ProtocolCheckMain(args []string) int {
+	fs := flag.NewFlagSet("protocol-check", flag.ContinueOnError)
+	srcDir := fs.String("srcDir", "./", "Dao package source dir.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	unlisted, err := UnlistedServerCalls(*srcDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, u := range unlisted {
+		fmt.Fprintln(os.Stderr, "not in serverCalls:", u)
+	}
+	if len(unlisted) > 0 {
+		return 1
+	}
+	fmt.Println("protocol-check: every sent call listed")
+	return 0
+}
@@ -0,0 +1,320 @@
+package dao
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ProtocolTypeSchema kinds: bool, int, float, string, array,
+// map, object, ref, any. ref points to ProtocolSchema.Types.
+type ProtocolTypeSchema struct {
+	Kind     string                 `json:"kind"`
+	Ref      string                 `json:"ref,omitempty"`
+	Format   string                 `json:"format,omitempty"`
+	Elem     *ProtocolTypeSchema    `json:"elem,omitempty"`
+	Fields   []*ProtocolFieldSchema `json:"fields,omitempty"`
+	Nullable bool                   `json:"nullable,omitempty"`
+}
+
+type ProtocolFieldSchema struct {
+	Name     string              `json:"name"`
+	Type     *ProtocolTypeSchema `json:"type"`
+	Optional bool                `json:"optional,omitempty"`
+}
+
+type ProtocolCallSchema struct {
+	Receiver string                 `json:"receiver"`
+	Method   string                 `json:"method"`
+	Require  string                 `json:"require,omitempty"`
+	Params   []*ProtocolFieldSchema `json:"params"`
+}
+
+type ProtocolSchema struct {
+	ClientVersion    string                         `json:"clientVersion"`
+	MinClientVersion string                         `json:"minClientVersion"`
+	Features         []string                       `json:"features"`
+	Codecs           []string                       `json:"codecs"`
+	ErrorCodes       []string                       `json:"errorCodes"`
+	ClientCalls      []*ProtocolCallSchema          `json:"clientCalls"`
+	ServerCalls      []*ProtocolCallSchema          `json:"serverCalls"`
+	Types            map[string]*ProtocolTypeSchema `json:"types"`
+}
+
+var clientCallRequireNames = map[ClientCallRequire]string{
+	RequireNone:      "none",
+	RequireNoAccount: "noAccount",
+	RequireAccount:   "account",
+	RequireChar:      "char",
+}
+
+var clientCallErrorCodes = []string{
+	ClientCallErrUnknownReceiver,
+	ClientCallErrMethodNotFound,
+	ClientCallErrInvalidParams,
+	ClientCallErrNotLoggedIn,
+	ClientCallErrAlreadyLoggedIn,
+	ClientCallErrNoUsingChar,
+	ClientCallErrRateLimited,
+}
+
+type serverCallParam struct {
+	name     string
+	value    interface{}
+	optional bool
+}
+
+type serverCall struct {
+	receiver string
+	method   string
+	params   []serverCallParam
+}
+
+type positionClient struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+type joinSceneClient struct {
+	SceneName string `json:"sceneName"`
+	Id        int    `json:"id"`
+}
+
+type successLoginAccountClient struct {
+	Username    string        `json:"username"`
+	CharConfigs []*CharClient `json:"charConfigs"`
+	ResumeToken string        `json:"resumeToken"`
+	Resumed     bool          `json:"resumed"`
+}
+
+type successLoginCharClient struct {
+	UsingChar int `json:"usingChar"`
+}
+
+type successCreateCharClient struct {
+	CharConfig *CharClient `json:"charConfig"`
+}
+
+type rateLimitWarningClient struct {
+	Receiver   string `json:"receiver"`
+	Method     string `json:"method"`
+	Violations int    `json:"violations"`
+}
+
+type helloClient struct {
+	ServerVersion string   `json:"serverVersion"`
+	Features      []string `json:"features"`
+	Codec         string   `json:"codec"`
+}
+
+type upgradeRequiredClient struct {
+	ClientVersion    string `json:"clientVersion"`
+	MinClientVersion string `json:"minClientVersion"`
+	ServerVersion    string `json:"serverVersion"`
+	Message          string `json:"message"`
+}
+
+type memberNameClient struct {
+	Name string `json:"name"`
+}
+
+// keep it same as what server send, payloads built by
+// map can not be described more than map.
+var serverCalls = []*serverCall{
+	// world
+	{"world", "handleSyncClient", []serverCallParam{{"version", map[string]string{}, false}}},
+	{"world", "handleClientCallError", []serverCallParam{{"error", &ClientCallError{}, false}}},
+	{"world", "handleHello", []serverCallParam{{"hello", &helloClient{}, false}}},
+	{"world", "handleUpgradeRequired", []serverCallParam{{"upgrade", &upgradeRequiredClient{}, false}}},
+	{"world", "handlePong", []serverCallParam{{"t", 0.0, false}}},
+	{"world", "handleRateLimitWarning", []serverCallParam{{"warning", &rateLimitWarningClient{}, false}}},
+	{"world", "handleSuccessRegisterAccount", []serverCallParam{{"message", "", false}}},
+	{"world", "handleErrorLoginAccount", []serverCallParam{{"message", "", false}}},
+	{"world", "handleErrorResumeSession", []serverCallParam{{"message", "", false}}},
+	{"world", "handleSuccessLoginAcccount", []serverCallParam{{"account", &successLoginAccountClient{}, false}}},
+	{"world", "handleSetLastUsername", []serverCallParam{{"username", "", false}}},
+	{"world", "handleAddScene", []serverCallParam{{"scene", &SceneClient{}, false}}},
+	{"world", "handleRunScene", []serverCallParam{{"sceneName", "", false}}},
+	{"world", "handleDestroyScene", []serverCallParam{{"sceneName", "", false}}},
+	// account
+	{"account", "handleSuccessLoginChar", []serverCallParam{{"account", &successLoginCharClient{}, false}}},
+	{"account", "handleSuccessCreateChar", []serverCallParam{{"char", &successCreateCharClient{}, false}}},
+	{"account", "handleErrorCreateChar", []serverCallParam{{"message", "", false}}},
+	// scene
+	{"scene", "handleAddChar", []serverCallParam{{"char", &CharClientBasic{}, false}}},
+	{"scene", "handleAddMob", []serverCallParam{{"mob", &MobClientBasic{}, false}}},
+	{"scene", "handleAddNpc", []serverCallParam{{"npc", &NpcClientBasic{}, false}}},
+	{"scene", "handleAddItem", []serverCallParam{{"item", &ItemClient{}, false}}},
+	{"scene", "handleAddFireBall", []serverCallParam{{"fireBall", &FireBallStateClient{}, false}}},
+	{"scene", "handleAddCleave", []serverCallParam{{"cleave", &CleaveClient{}, false}}},
+	{"scene", "handleAddPortal", []serverCallParam{{"portal", &PortalClient{}, false}}},
+	{"scene", "handleRemoveById", []serverCallParam{{"id", 0, false}, {"sceneName", "", false}}},
+	// bio
+	{"bio", "handleMoveStateChange", []serverCallParam{{"id", 0, false}, {"moveState", &MoveStateClient{}, false}}},
+	{"bio", "handleUpdateBioConfig", []serverCallParam{{"id", 0, false}, {"config", map[string]int{}, false}}},
+	{"bio", "handleUpdateCpBody", []serverCallParam{{"id", 0, false}, {"cpBody", map[string]float32{}, false}}},
+	{"bio", "handleItemQuickHeal", []serverCallParam{{"id", 0, false}, {"heal", 0, false}, {"effectId", 0, false}}},
+	{"bio", "handlePartyCreateBasic", []serverCallParam{{"id", 0, false}, {"party", &PartyClientBasic{}, false}}},
+	// char
+	{"char", "handleJoinScene", []serverCallParam{{"scene", &joinSceneClient{}, false}}},
+	{"char", "handleLeaveScene", nil},
+	{"char", "handleSetPosition", []serverCallParam{{"position", &positionClient{}, false}}},
+	{"char", "handleViewSnapshot", []serverCallParam{{"snapshot", &ViewSnapshotDeltaClient{}, false}}},
+	{"char", "handleChatMessage", []serverCallParam{{"message", &ChatMessageClient{}, false}}},
+	{"char", "handleNpcTalkBox", []serverCallParam{{"talk", &NpcTalkClient{}, false}}},
+	{"char", "handleShop", []serverCallParam{{"shop", &ShopClient{}, false}}},
+	{"char", "handleUpdateConfig", []serverCallParam{{"config", map[string]interface{}{}, false}}},
+	{"char", "handleUpdateItems", []serverCallParam{{"items", map[string]map[string]interface{}{}, false}, {"isMerge", false, true}}},
+	{"char", "handleUpdateUsingEquips", []serverCallParam{{"usingEquips", map[string]interface{}{}, false}}},
+	{"char", "handleLearnedSkills", []serverCallParam{{"skills", map[string]int{}, false}}},
+	{"char", "handleQuests", []serverCallParam{{"quests", map[string]*QuestClient{}, false}, {"isUpsert", false, true}}},
+	{"char", "handlePartyCreate", []serverCallParam{{"party", &PartyClient{}, false}}},
+	{"char", "handlePartyAdd", []serverCallParam{{"member", &MemberInfo{}, false}}},
+	{"char", "handlePartyRemove", []serverCallParam{{"member", &memberNameClient{}, false}}},
+}
+
+type protocolSchemaBuilder struct {
+	types map[string]*ProtocolTypeSchema
+}
+
+func (s *Server) ProtocolSchema() *ProtocolSchema {
+	b := &protocolSchemaBuilder{
+		types: make(map[string]*ProtocolTypeSchema),
+	}
+	schema := &ProtocolSchema{
+		ClientVersion:    s.configs.ServerConfigs.ClientVersion,
+		MinClientVersion: s.configs.ServerConfigs.MinClientVersion,
+		Features:         ProtocolFeatures,
+		Codecs:           ClientCallCodecNames,
+		ErrorCodes:       clientCallErrorCodes,
+		ClientCalls:      make([]*ProtocolCallSchema, 0),
+		ServerCalls:      make([]*ProtocolCallSchema, 0, len(serverCalls)),
+		Types:            b.types,
+	}
+	for _, h := range s.world.clientCallRegistry.Handlers() {
+		call := &ProtocolCallSchema{
+			Receiver: h.Receiver,
+			Method:   h.Method,
+			Require:  clientCallRequireNames[h.Require],
+			Params:   make([]*ProtocolFieldSchema, len(h.params)),
+		}
+		for i, p := range h.params {
+			call.Params[i] = &ProtocolFieldSchema{
+				Name:     p.name,
+				Type:     b.typeSchema(h.paramsType.Field(p.index).Type),
+				Optional: p.optional,
+			}
+		}
+		schema.ClientCalls = append(schema.ClientCalls, call)
+	}
+	for _, sc := range serverCalls {
+		call := &ProtocolCallSchema{
+			Receiver: sc.receiver,
+			Method:   sc.method,
+			Params:   make([]*ProtocolFieldSchema, len(sc.params)),
+		}
+		for i, p := range sc.params {
+			call.Params[i] = &ProtocolFieldSchema{
+				Name:     p.name,
+				Type:     b.typeSchema(reflect.TypeOf(p.value)),
+				Optional: p.optional,
+			}
+		}
+		schema.ServerCalls = append(schema.ServerCalls, call)
+	}
+	return schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (b *protocolSchemaBuilder) typeSchema(t reflect.Type) *ProtocolTypeSchema {
+	if t == nil {
+		return &ProtocolTypeSchema{Kind: "any"}
+	}
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		nullable = true
+		t = t.Elem()
+	}
+	var ts *ProtocolTypeSchema
+	switch t.Kind() {
+	case reflect.Bool:
+		ts = &ProtocolTypeSchema{Kind: "bool"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		ts = &ProtocolTypeSchema{Kind: "int"}
+	case reflect.Float32, reflect.Float64:
+		ts = &ProtocolTypeSchema{Kind: "float"}
+	case reflect.String:
+		ts = &ProtocolTypeSchema{Kind: "string"}
+	case reflect.Slice, reflect.Array:
+		ts = &ProtocolTypeSchema{Kind: "array", Elem: b.typeSchema(t.Elem())}
+		nullable = nullable || t.Kind() == reflect.Slice
+	case reflect.Map:
+		ts = &ProtocolTypeSchema{Kind: "map", Elem: b.typeSchema(t.Elem())}
+		nullable = true
+	case reflect.Struct:
+		if t == timeType {
+			ts = &ProtocolTypeSchema{Kind: "string", Format: "date-time"}
+		} else if t.Name() == "" {
+			ts = &ProtocolTypeSchema{Kind: "object", Fields: b.fields(t)}
+		} else {
+			b.defineStruct(t)
+			ts = &ProtocolTypeSchema{Kind: "ref", Ref: t.Name()}
+		}
+	default:
+		ts = &ProtocolTypeSchema{Kind: "any"}
+	}
+	ts.Nullable = nullable
+	return ts
+}
+
+func (b *protocolSchemaBuilder) defineStruct(t reflect.Type) {
+	if _, ok := b.types[t.Name()]; ok {
+		return
+	}
+	ts := &ProtocolTypeSchema{Kind: "object"}
+	// set before fields, struct may ref itself.
+	b.types[t.Name()] = ts
+	ts.Fields = b.fields(t)
+}
+
+func (b *protocolSchemaBuilder) fields(t reflect.Type) []*ProtocolFieldSchema {
+	fields := make([]*ProtocolFieldSchema, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, b.fields(ft)...)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		field := &ProtocolFieldSchema{
+			Name: name,
+			Type: b.typeSchema(f.Type),
+		}
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				field.Optional = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
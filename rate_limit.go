@@ -0,0 +1,176 @@
+package dao
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// each connection can not make more buckets than it,
+// other methods share one bucket.
+const maxRateLimitMethodBuckets = 64
+
+type RateLimitAction int
+
+const (
+	RateLimitAllow RateLimitAction = iota
+	RateLimitDrop
+	RateLimitWarn
+	RateLimitKick
+)
+
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(conf *RateLimitConfig, now time.Time) *TokenBucket {
+	return &TokenBucket{
+		rate:   conf.Rate,
+		burst:  conf.Burst,
+		tokens: conf.Burst,
+		last:   now,
+	}
+}
+
+func (tb *TokenBucket) Allow(now time.Time) bool {
+	elapsed := now.Sub(tb.last).Seconds()
+	tb.last = now
+	tb.tokens += elapsed * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens -= 1
+	return true
+}
+
+type RateLimitStats struct {
+	Dropped int64 `json:"dropped"`
+	Warned  int64 `json:"warned"`
+	Kicked  int64 `json:"kicked"`
+	//
+	mutex              sync.Mutex
+	violationsByMethod map[string]int64
+}
+
+type RateLimitStatsClient struct {
+	Dropped            int64            `json:"dropped"`
+	Warned             int64            `json:"warned"`
+	Kicked             int64            `json:"kicked"`
+	ViolationsByMethod map[string]int64 `json:"violationsByMethod"`
+}
+
+func NewRateLimitStats() *RateLimitStats {
+	return &RateLimitStats{
+		violationsByMethod: make(map[string]int64),
+	}
+}
+
+func (rs *RateLimitStats) addViolation(key string, action RateLimitAction) {
+	switch action {
+	case RateLimitDrop:
+		atomic.AddInt64(&rs.Dropped, 1)
+	case RateLimitWarn:
+		atomic.AddInt64(&rs.Warned, 1)
+	case RateLimitKick:
+		atomic.AddInt64(&rs.Kicked, 1)
+	}
+	rs.mutex.Lock()
+	rs.violationsByMethod[key] += 1
+	rs.mutex.Unlock()
+}
+
+func (rs *RateLimitStats) Client() *RateLimitStatsClient {
+	rs.mutex.Lock()
+	byMethod := make(map[string]int64, len(rs.violationsByMethod))
+	for k, v := range rs.violationsByMethod {
+		byMethod[k] = v
+	}
+	rs.mutex.Unlock()
+	return &RateLimitStatsClient{
+		Dropped:            atomic.LoadInt64(&rs.Dropped),
+		Warned:             atomic.LoadInt64(&rs.Warned),
+		Kicked:             atomic.LoadInt64(&rs.Kicked),
+		ViolationsByMethod: byMethod,
+	}
+}
+
+// ConnRateLimiter only used by conn's readRun, no lock.
+type ConnRateLimiter struct {
+	configs       *RateLimitConfigs
+	stats         *RateLimitStats
+	conn          *TokenBucket
+	methods       map[string]*TokenBucket
+	violations    int
+	lastViolation time.Time
+}
+
+func NewConnRateLimiter(configs *RateLimitConfigs, stats *RateLimitStats) *ConnRateLimiter {
+	now := time.Now()
+	return &ConnRateLimiter{
+		configs: configs,
+		stats:   stats,
+		conn:    NewTokenBucket(configs.Connection, now),
+		methods: make(map[string]*TokenBucket),
+	}
+}
+
+func (rl *ConnRateLimiter) methodBucket(key string, now time.Time) *TokenBucket {
+	bucket, ok := rl.methods[key]
+	if ok {
+		return bucket
+	}
+	conf, isCustom := rl.configs.Methods[key]
+	if !isCustom {
+		conf = rl.configs.Default
+		if len(rl.methods) >= maxRateLimitMethodBuckets {
+			key = "*"
+			if bucket, ok := rl.methods[key]; ok {
+				return bucket
+			}
+		}
+	}
+	bucket = NewTokenBucket(conf, now)
+	rl.methods[key] = bucket
+	return bucket
+}
+
+func (rl *ConnRateLimiter) Check(c *ClientCall) RateLimitAction {
+	if !rl.configs.Enable {
+		return RateLimitAllow
+	}
+	now := time.Now()
+	key := c.Receiver + "." + c.Method
+	// both bucket take token, don't short-circuit it.
+	connOk := true
+	if conf, ok := rl.configs.Methods[key]; !ok || !conf.SkipConnection {
+		connOk = rl.conn.Allow(now)
+	}
+	methodOk := rl.methodBucket(key, now).Allow(now)
+	if connOk && methodOk {
+		return RateLimitAllow
+	}
+	resetAfter := time.Duration(rl.configs.ViolationResetSeconds) * time.Second
+	if now.Sub(rl.lastViolation) > resetAfter {
+		rl.violations = 0
+	}
+	rl.violations += 1
+	rl.lastViolation = now
+	action := RateLimitDrop
+	if rl.violations >= rl.configs.KickAfter {
+		action = RateLimitKick
+	} else if rl.violations == rl.configs.WarnAfter {
+		action = RateLimitWarn
+	}
+	rl.stats.addViolation(key, action)
+	return action
+}
+
+func (rl *ConnRateLimiter) Violations() int {
+	return rl.violations
+}
@@ -28,6 +28,12 @@ type Scene struct {
 	autoSaveCharsDuration time.Duration
 	//
 	enableNoUpdateOnZeroChar bool
+	// nil if not from db/scenes.
+	def        *SceneDef
+	defNpcs    []Npcer
+	defPortals []*Portal
+	// entered in update, teleported by world after it.
+	portalQueue []*portalEnter
 }
 
 type SceneInfo struct {
@@ -64,6 +70,8 @@ type SceneClient struct {
 	Height      float32         `json:"height"`
 	//
 	DefaultGroundTextureName string `json:"defaultGroundTextureName"`
+	// nil if scene not from tiled map.
+	TiledMap *TiledMapClient `json:"tiledMap,omitempty"`
 }
 
 func (s *Scene) Name() string {
@@ -77,6 +85,10 @@ func (s *Scene) SceneClient() *SceneClient {
 		cpBodyClients[i] = ToCpBodyClient(sbody)
 		i = i + 1
 	}
+	var tiledMap *TiledMapClient
+	if s.def != nil && s.def.tiled != nil {
+		tiledMap = s.def.tiled.Client()
+	}
 	return &SceneClient{
 		Name:        s.name,
 		StaticBodys: cpBodyClients,
@@ -84,11 +96,12 @@ func (s *Scene) SceneClient() *SceneClient {
 		Width:       s.width,
 		Height:      s.height,
 		DefaultGroundTextureName: s.defaultGroundTextureName,
+		TiledMap:                 tiledMap,
 	}
 }
 
-func NewWallScene(world *World, name string, w vect.Float, h vect.Float) *Scene {
-	s := NewScene(world, name)
+// NewWallBody is a static body of walls on bounds of w*h centered box.
+func NewWallBody(w vect.Float, h vect.Float) *chipmunk.Body {
 	boxWall := chipmunk.NewBodyStatic()
 	wallTop := chipmunk.NewSegment(vect.Vect{X: -w / 2, Y: h / 2}, vect.Vect{X: w / 2, Y: h / 2}, 0)
 	wallTop.SetFriction(0)
@@ -106,6 +119,12 @@ func NewWallScene(world *World, name string, w vect.Float, h vect.Float) *Scene
 	wallRight.SetFriction(0)
 	wallRight.SetElasticity(0)
 	boxWall.AddShape(wallRight)
+	return boxWall
+}
+
+func NewWallScene(world *World, name string, w vect.Float, h vect.Float) *Scene {
+	s := NewScene(world, name)
+	boxWall := NewWallBody(w, h)
 	s.cpSpace.AddBody(boxWall)
 	s.staticBodys[boxWall] = struct{}{}
 	s.width = float32(w)
@@ -136,10 +155,14 @@ func (s *Scene) Update(delta float32) {
 			char.InSceneDuration() >= s.autoSaveCharsDuration {
 			char.SetInSceneDuration(time.Duration(0))
 			dump := char.DumpDB()
-			go char.SaveByDumpDB(dump)
+			char.SaveByDumpDB(dump)
+			char.SnapshotByDumpDB(dump, CharSnapshotAutoSave)
 			continue
 		}
 	}
+	for _, char := range s.chars {
+		char.UpdateViewSnapshot()
+	}
 }
 
 type ClientCallPublisher interface {
@@ -154,6 +177,15 @@ func (s *Scene) DispatchClientCall(sender ClientCallPublisher, c *ClientCall) {
 	}
 }
 
+// DispatchLegacyClientCall skips chars get same state by view snapshot.
+func (s *Scene) DispatchLegacyClientCall(sender ClientCallPublisher, c *ClientCall) {
+	for _, char := range s.chars {
+		if char.Scene() != nil && !char.ViewSnapshotEnabled() {
+			char.OnReceiveClientCall(sender, c)
+		}
+	}
+}
+
 func (s *Scene) FindMobById(mid int) *Mob {
 	mob, ok := s.sceneObjects[mid].(*Mob)
 	if ok {
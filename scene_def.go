@@ -0,0 +1,469 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xuhaojun/chipmunk"
+	"github.com/xuhaojun/chipmunk/vect"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const SceneDefDir = "db/scenes/"
+
+type SceneVect struct {
+	X float32 `yaml:"x" json:"x"`
+	Y float32 `yaml:"y" json:"y"`
+}
+
+func (v SceneVect) Vect() vect.Vect {
+	return vect.Vect{X: vect.Float(v.X), Y: vect.Float(v.Y)}
+}
+
+type SceneSegmentDef struct {
+	A      SceneVect `yaml:"a"`
+	B      SceneVect `yaml:"b"`
+	Radius float32   `yaml:"radius,omitempty"`
+}
+
+// ScenePolygonDef must be convex, either winding.
+type ScenePolygonDef struct {
+	Verts  []SceneVect `yaml:"verts"`
+	Offset SceneVect   `yaml:"offset,omitempty"`
+}
+
+// ScenePortalDef is an area moves chars to spawn point of target scene,
+// X and Y are its center.
+type ScenePortalDef struct {
+	Name        string  `yaml:"name"`
+	X           float32 `yaml:"x"`
+	Y           float32 `yaml:"y"`
+	Width       float32 `yaml:"width"`
+	Height      float32 `yaml:"height"`
+	TargetScene string  `yaml:"targetScene"`
+	// empty for default spawn point.
+	TargetSpawn string `yaml:"targetSpawn,omitempty"`
+	// requirements
+	MinLevel        int  `yaml:"minLevel,omitempty"`
+	QuestId         int  `yaml:"questId,omitempty"`
+	QuestComplete   bool `yaml:"questComplete,omitempty"`
+	RequireParty    bool `yaml:"requireParty,omitempty"`
+	CooldownSeconds int  `yaml:"cooldownSeconds,omitempty"`
+}
+
+type SceneNpcDef struct {
+	BaseId int     `yaml:"baseId"`
+	X      float32 `yaml:"x"`
+	Y      float32 `yaml:"y"`
+}
+
+type SceneSpawnPoint struct {
+	Name string  `yaml:"name" json:"name"`
+	X    float32 `yaml:"x" json:"x"`
+	Y    float32 `yaml:"y" json:"y"`
+}
+
+// SceneDef is one file of db/scenes, scene center is (0, 0).
+// Things of TiledMap are appended to the ones of file,
+// size of map replaces width and height.
+type SceneDef struct {
+	Name          string  `yaml:"name"`
+	Width         float32 `yaml:"width"`
+	Height        float32 `yaml:"height"`
+	GroundTexture string  `yaml:"groundTexture,omitempty"`
+	// file of TiledMapDir
+	TiledMap string `yaml:"tiledMap,omitempty"`
+	// no walls on bounds.
+	NoWalls            bool `yaml:"noWalls,omitempty"`
+	NoUpdateOnZeroChar bool `yaml:"noUpdateOnZeroChar,omitempty"`
+	// static obstacles
+	Segments []*SceneSegmentDef `yaml:"segments,omitempty"`
+	Polygons []*ScenePolygonDef `yaml:"polygons,omitempty"`
+	//
+	SpawnPoints []*SceneSpawnPoint `yaml:"spawnPoints,omitempty"`
+	Portals     []*ScenePortalDef  `yaml:"portals,omitempty"`
+	Npcs        []*SceneNpcDef     `yaml:"npcs,omitempty"`
+	// zero fields use SceneConfigs.Default,
+	// SceneConfigs.Custom still overrides them.
+	Settings *SceneBaseConfig `yaml:"settings,omitempty"`
+	//
+	file  string
+	tiled *TiledMap
+}
+
+type SceneDefErrors []string
+
+func (es SceneDefErrors) Error() string {
+	return fmt.Sprintf("%d invalid scene defs: %s", len(es), strings.Join(es, "; "))
+}
+
+// LoadSceneDefs reads and validates every yaml file of dir,
+// sorted by file name.
+func LoadSceneDefs(dir string) ([]*SceneDef, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		return nil, errors.New("scene defs: no yaml in " + dir)
+	}
+	defs := make([]*SceneDef, 0, len(files))
+	errs := SceneDefErrors{}
+	names := make(map[string]string, len(files))
+	for _, file := range files {
+		dat, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		def := &SceneDef{file: file}
+		if err := yaml.Unmarshal(dat, def); err != nil {
+			errs = append(errs, file+": "+err.Error())
+			continue
+		}
+		if def.TiledMap != "" {
+			tm, err := LoadTiledMap(def.TiledMap)
+			if err != nil {
+				errs = append(errs, file+": "+err.Error())
+				continue
+			}
+			for _, msg := range def.applyTiledMap(tm) {
+				errs = append(errs, file+": "+msg)
+			}
+		}
+		for _, msg := range def.validate() {
+			errs = append(errs, file+": "+msg)
+		}
+		if other, dup := names[def.Name]; dup {
+			errs = append(errs, file+": name "+def.Name+" also in "+other)
+		}
+		names[def.Name] = file
+		defs = append(defs, def)
+	}
+	byName := make(map[string]*SceneDef, len(defs))
+	for _, def := range defs {
+		byName[def.Name] = def
+	}
+	for _, def := range defs {
+		for i, portal := range def.Portals {
+			target, ok := byName[portal.TargetScene]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: portals[%d] target scene %q not defined",
+					def.file, i, portal.TargetScene))
+			} else if portal.TargetSpawn != "" && target.spawnPoint(portal.TargetSpawn) == nil {
+				errs = append(errs, fmt.Sprintf("%s: portals[%d] target spawn %q not in %s",
+					def.file, i, portal.TargetSpawn, target.Name))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return defs, nil
+}
+
+// validate also makes polygons clockwise as chipmunk wants.
+func (def *SceneDef) validate() (msgs []string) {
+	fail := func(format string, a ...interface{}) {
+		msgs = append(msgs, fmt.Sprintf(format, a...))
+	}
+	if def.Name == "" {
+		fail("missing name")
+	}
+	if def.Width <= 0 || def.Height <= 0 {
+		fail("width and height must be positive")
+	}
+	for i, seg := range def.Segments {
+		if seg.A == seg.B {
+			fail("segments[%d] has zero length", i)
+		}
+	}
+	for i, poly := range def.Polygons {
+		if len(poly.Verts) < 3 {
+			fail("polygons[%d] needs at least 3 verts", i)
+			continue
+		}
+		switch polygonWinding(poly.Verts) {
+		case 0:
+			fail("polygons[%d] not convex", i)
+		case 1:
+			for l, r := 0, len(poly.Verts)-1; l < r; l, r = l+1, r-1 {
+				poly.Verts[l], poly.Verts[r] = poly.Verts[r], poly.Verts[l]
+			}
+		}
+	}
+	inBounds := func(x float32, y float32) bool {
+		return x >= -def.Width/2 && x <= def.Width/2 &&
+			y >= -def.Height/2 && y <= def.Height/2
+	}
+	spawnNames := make(map[string]bool, len(def.SpawnPoints))
+	for i, sp := range def.SpawnPoints {
+		if sp.Name == "" || spawnNames[sp.Name] {
+			fail("spawnPoints[%d] name empty or duplicated", i)
+		}
+		spawnNames[sp.Name] = true
+		if !inBounds(sp.X, sp.Y) {
+			fail("spawnPoints[%d] %s out of bounds", i, sp.Name)
+		}
+	}
+	for i, portal := range def.Portals {
+		if portal.Width <= 0 || portal.Height <= 0 {
+			fail("portals[%d] %s width and height must be positive", i, portal.Name)
+		}
+		if !inBounds(portal.X, portal.Y) {
+			fail("portals[%d] %s out of bounds", i, portal.Name)
+		}
+		if portal.MinLevel < 0 || portal.QuestId < 0 || portal.CooldownSeconds < 0 {
+			fail("portals[%d] %s requirements must not be negative", i, portal.Name)
+		}
+	}
+	for i, npc := range def.Npcs {
+		if npc.BaseId <= 0 {
+			fail("npcs[%d] baseId must be positive", i)
+		}
+		if !inBounds(npc.X, npc.Y) {
+			fail("npcs[%d] out of bounds", i)
+		}
+	}
+	if s := def.Settings; s != nil &&
+		(s.AutoClearItemDuration < 0 || s.AutoSaveCharsDuration < 0) {
+		fail("settings durations must not be negative")
+	}
+	return
+}
+
+// polygonWinding returns 1 for counter-clockwise, -1 for clockwise,
+// 0 if not convex.
+func polygonWinding(verts []SceneVect) int {
+	sign := 0
+	n := len(verts)
+	for i := range verts {
+		a, b, c := verts[i], verts[(i+1)%n], verts[(i+2)%n]
+		cross := (b.X-a.X)*(c.Y-b.Y) - (b.Y-a.Y)*(c.X-b.X)
+		s := 0
+		if cross > 0 {
+			s = 1
+		} else if cross < 0 {
+			s = -1
+		}
+		if s == 0 || (sign != 0 && s != sign) {
+			return 0
+		}
+		sign = s
+	}
+	return sign
+}
+
+// obstacleBody returns nil if no obstacles.
+func (def *SceneDef) obstacleBody() *chipmunk.Body {
+	if len(def.Segments) == 0 && len(def.Polygons) == 0 {
+		return nil
+	}
+	body := chipmunk.NewBodyStatic()
+	for _, seg := range def.Segments {
+		shape := chipmunk.NewSegment(seg.A.Vect(), seg.B.Vect(), vect.Float(seg.Radius))
+		shape.SetFriction(0)
+		shape.SetElasticity(0)
+		body.AddShape(shape)
+	}
+	for _, poly := range def.Polygons {
+		verts := make(chipmunk.Vertices, len(poly.Verts))
+		for i, v := range poly.Verts {
+			verts[i] = v.Vect()
+		}
+		shape := chipmunk.NewPolygon(verts, poly.Offset.Vect())
+		shape.SetFriction(0)
+		shape.SetElasticity(0)
+		body.AddShape(shape)
+	}
+	return body
+}
+
+func (def *SceneDef) NewScene(w *World) *Scene {
+	s := NewScene(w, def.Name)
+	s.applyDef(def)
+	return s
+}
+
+// applyDef replaces static bodys and settings of scene,
+// objects in it are kept.
+func (s *Scene) applyDef(def *SceneDef) {
+	for body := range s.staticBodys {
+		s.RemoveBody(body)
+		delete(s.staticBodys, body)
+	}
+	addStatic := func(body *chipmunk.Body) {
+		s.AddBody(body)
+		s.staticBodys[body] = struct{}{}
+	}
+	if !def.NoWalls {
+		addStatic(NewWallBody(vect.Float(def.Width), vect.Float(def.Height)))
+	}
+	if body := def.obstacleBody(); body != nil {
+		addStatic(body)
+	}
+	s.width = def.Width
+	s.height = def.Height
+	s.defaultGroundTextureName = "grass"
+	if def.GroundTexture != "" {
+		s.defaultGroundTextureName = def.GroundTexture
+	}
+	s.enableNoUpdateOnZeroChar = def.NoUpdateOnZeroChar
+	s.def = def
+	s.placeDefNpcs()
+	s.placeDefPortals()
+}
+
+// placeDefNpcs replaces npcs placed by def,
+// call it again after RemoveAllNpcer.
+func (s *Scene) placeDefNpcs() {
+	for _, npc := range s.defNpcs {
+		s.Remove(npc.SceneObjecter())
+	}
+	s.defNpcs = nil
+	if s.def == nil {
+		return
+	}
+	for _, npcDef := range s.def.Npcs {
+		npc := NewNpcByBaseId(s.world, npcDef.BaseId)
+		npc.SetPosition(npcDef.X, npcDef.Y)
+		s.Add(npc.SceneObjecter())
+		s.defNpcs = append(s.defNpcs, npc)
+	}
+}
+
+func (def *SceneDef) spawnPoint(name string) *SceneSpawnPoint {
+	for _, sp := range def.SpawnPoints {
+		if sp.Name == name {
+			return sp
+		}
+	}
+	return nil
+}
+
+func (s *Scene) SpawnPoint(name string) *SceneSpawnPoint {
+	if s.def == nil {
+		return nil
+	}
+	return s.def.spawnPoint(name)
+}
+
+// DefaultSpawnPoint is the one named default, or the first one,
+// or center of scene.
+func (s *Scene) DefaultSpawnPoint() *SceneSpawnPoint {
+	if sp := s.SpawnPoint("default"); sp != nil {
+		return sp
+	}
+	if s.def != nil && len(s.def.SpawnPoints) > 0 {
+		return s.def.SpawnPoints[0]
+	}
+	return &SceneSpawnPoint{Name: "default"}
+}
+
+// refreshClients resends scene to chars in it.
+func (s *Scene) refreshClients() {
+	sceneParam := s.SceneClient()
+	for _, char := range s.chars {
+		pos := char.Body().Position()
+		char.SendClientCalls([]*ClientCall{
+			&ClientCall{
+				Receiver: "char",
+				Method:   "handleLeaveScene",
+				Params:   []interface{}{},
+			},
+			&ClientCall{
+				Receiver: "world",
+				Method:   "handleDestroyScene",
+				Params:   []interface{}{s.name},
+			},
+			&ClientCall{
+				Receiver: "world",
+				Method:   "handleAddScene",
+				Params:   []interface{}{sceneParam},
+			},
+			&ClientCall{
+				Receiver: "world",
+				Method:   "handleRunScene",
+				Params:   []interface{}{s.name},
+			},
+			&ClientCall{
+				Receiver: "char",
+				Method:   "handleJoinScene",
+				Params: []interface{}{map[string]interface{}{
+					"sceneName": s.name,
+					"id":        char.Id(),
+				}},
+			},
+			&ClientCall{
+				Receiver: "char",
+				Method:   "handleSetPosition",
+				Params: []interface{}{map[string]float32{
+					"x": float32(pos.X),
+					"y": float32(pos.Y),
+				}},
+			},
+		})
+		char.ResyncViewAOI()
+	}
+}
+
+func checkSceneDefs(defs []*SceneDef, configs *DaoConfigs) error {
+	first := configs.CharConfigs.FirstScene.Name
+	for _, def := range defs {
+		if def.Name == first {
+			return nil
+		}
+	}
+	return errors.New("scene defs: first scene " + first + " not defined")
+}
+
+func (w *World) loadScenes() error {
+	defs, err := LoadSceneDefs(SceneDefDir)
+	if err != nil {
+		return err
+	}
+	if err := checkSceneDefs(defs, w.configs); err != nil {
+		return err
+	}
+	for _, def := range defs {
+		w.scenes[def.Name] = def.NewScene(w)
+	}
+	return nil
+}
+
+// ReloadScenes keeps current scenes if any file is invalid,
+// scenes not in files any more are kept too.
+func (w *World) ReloadScenes() (err error) {
+	w.logger.Println("Reloading Scenes")
+	defs, err := LoadSceneDefs(SceneDefDir)
+	if err == nil {
+		err = checkSceneDefs(defs, w.configs)
+	}
+	if err != nil {
+		w.logger.Println("Error ReloadScenes", err)
+		return
+	}
+	defined := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		defined[def.Name] = true
+		scene, ok := w.scenes[def.Name]
+		if !ok {
+			w.scenes[def.Name] = def.NewScene(w)
+			w.logger.Println("Scene:", def.Name, "added.")
+			continue
+		}
+		scene.applyDef(def)
+		scene.refreshClients()
+	}
+	for name := range w.scenes {
+		if !defined[name] {
+			w.logger.Println("Scene:", name, "not in", SceneDefDir+", kept.")
+		}
+	}
+	w.configs.SceneConfigs.SetScenes(w.scenes)
+	w.logger.Println("Reloaded Scenes!")
+	return
+}
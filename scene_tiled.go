@@ -0,0 +1,545 @@
+package dao
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tiled maps live here, web serves them on TiledMapUrlPrefix
+// so client loads the same file.
+const (
+	TiledMapDir       = "db/maps/"
+	TiledMapUrlPrefix = "/maps"
+)
+
+// object layers of these names are read, others are client only.
+const (
+	TiledLayerCollision = "collision"
+	TiledLayerSpawns    = "spawns"
+	TiledLayerPortals   = "portals"
+	TiledLayerNpcs      = "npcs"
+)
+
+type TiledTileset struct {
+	FirstGid int    `json:"firstGid"`
+	Name     string `json:"name"`
+	Image    string `json:"image,omitempty"`
+	// external tileset file
+	Source string `json:"source,omitempty"`
+}
+
+type TiledObject struct {
+	Id         int
+	Name       string
+	Type       string
+	X          float32
+	Y          float32
+	Width      float32
+	Height     float32
+	Rotation   float32
+	Point      bool
+	Ellipse    bool
+	Polygon    []SceneVect
+	Polyline   []SceneVect
+	Properties map[string]string
+}
+
+type TiledObjectGroup struct {
+	Name    string
+	Objects []*TiledObject
+}
+
+// TiledMap is what server needs of a Tiled map, json or tmx.
+type TiledMap struct {
+	File         string
+	Version      string
+	Orientation  string
+	Width        int
+	Height       int
+	TileWidth    int
+	TileHeight   int
+	TileLayers   []string
+	Tilesets     []*TiledTileset
+	ObjectGroups []*TiledObjectGroup
+}
+
+type TiledMapClient struct {
+	Url        string          `json:"url"`
+	Width      int             `json:"width"`
+	Height     int             `json:"height"`
+	TileWidth  int             `json:"tileWidth"`
+	TileHeight int             `json:"tileHeight"`
+	TileLayers []string        `json:"tileLayers"`
+	Tilesets   []*TiledTileset `json:"tilesets"`
+}
+
+func (tm *TiledMap) Client() *TiledMapClient {
+	return &TiledMapClient{
+		Url:        TiledMapUrlPrefix + "/" + tm.File + "?v=" + tm.Version,
+		Width:      tm.Width,
+		Height:     tm.Height,
+		TileWidth:  tm.TileWidth,
+		TileHeight: tm.TileHeight,
+		TileLayers: tm.TileLayers,
+		Tilesets:   tm.Tilesets,
+	}
+}
+
+func (tm *TiledMap) PixelSize() (float32, float32) {
+	return float32(tm.Width * tm.TileWidth), float32(tm.Height * tm.TileHeight)
+}
+
+// LoadTiledMap reads file of TiledMapDir, .tmx as xml, others as json.
+func LoadTiledMap(file string) (*TiledMap, error) {
+	dat, err := ioutil.ReadFile(filepath.Join(TiledMapDir, file))
+	if err != nil {
+		return nil, err
+	}
+	var tm *TiledMap
+	if strings.ToLower(filepath.Ext(file)) == ".tmx" {
+		tm, err = parseTiledTmx(dat)
+	} else {
+		tm, err = parseTiledJson(dat)
+	}
+	if err != nil {
+		return nil, errors.New("tiled map " + file + ": " + err.Error())
+	}
+	tm.File = filepath.ToSlash(file)
+	tm.Version = strconv.FormatUint(uint64(crc32.ChecksumIEEE(dat)), 16)
+	if tm.Orientation != "orthogonal" {
+		return nil, errors.New("tiled map " + file + ": only orthogonal supported")
+	}
+	if tm.Width <= 0 || tm.Height <= 0 || tm.TileWidth <= 0 || tm.TileHeight <= 0 {
+		return nil, errors.New("tiled map " + file + ": invalid size")
+	}
+	return tm, nil
+}
+
+type tiledJsonObject struct {
+	Id         int         `json:"id"`
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	X          float32     `json:"x"`
+	Y          float32     `json:"y"`
+	Width      float32     `json:"width"`
+	Height     float32     `json:"height"`
+	Rotation   float32     `json:"rotation"`
+	Point      bool        `json:"point"`
+	Ellipse    bool        `json:"ellipse"`
+	Polygon    []SceneVect `json:"polygon"`
+	Polyline   []SceneVect `json:"polyline"`
+	Properties interface{} `json:"properties"`
+}
+
+type tiledJsonLayer struct {
+	Type    string             `json:"type"`
+	Name    string             `json:"name"`
+	Objects []*tiledJsonObject `json:"objects"`
+	// of group
+	Layers []*tiledJsonLayer `json:"layers"`
+}
+
+type tiledJsonMap struct {
+	Orientation string            `json:"orientation"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	TileWidth   int               `json:"tilewidth"`
+	TileHeight  int               `json:"tileheight"`
+	Layers      []*tiledJsonLayer `json:"layers"`
+	Tilesets    []struct {
+		FirstGid int    `json:"firstgid"`
+		Name     string `json:"name"`
+		Image    string `json:"image"`
+		Source   string `json:"source"`
+	} `json:"tilesets"`
+}
+
+func parseTiledJson(dat []byte) (*TiledMap, error) {
+	jm := &tiledJsonMap{}
+	if err := json.Unmarshal(dat, jm); err != nil {
+		return nil, err
+	}
+	tm := &TiledMap{
+		Orientation: jm.Orientation,
+		Width:       jm.Width,
+		Height:      jm.Height,
+		TileWidth:   jm.TileWidth,
+		TileHeight:  jm.TileHeight,
+	}
+	for _, ts := range jm.Tilesets {
+		tm.Tilesets = append(tm.Tilesets, &TiledTileset{
+			FirstGid: ts.FirstGid,
+			Name:     ts.Name,
+			Image:    ts.Image,
+			Source:   ts.Source,
+		})
+	}
+	var addLayers func(layers []*tiledJsonLayer)
+	addLayers = func(layers []*tiledJsonLayer) {
+		for _, layer := range layers {
+			switch layer.Type {
+			case "tilelayer":
+				tm.TileLayers = append(tm.TileLayers, layer.Name)
+			case "objectgroup":
+				group := &TiledObjectGroup{Name: layer.Name}
+				for _, jo := range layer.Objects {
+					group.Objects = append(group.Objects, &TiledObject{
+						Id:         jo.Id,
+						Name:       jo.Name,
+						Type:       jo.Type,
+						X:          jo.X,
+						Y:          jo.Y,
+						Width:      jo.Width,
+						Height:     jo.Height,
+						Rotation:   jo.Rotation,
+						Point:      jo.Point,
+						Ellipse:    jo.Ellipse,
+						Polygon:    jo.Polygon,
+						Polyline:   jo.Polyline,
+						Properties: tiledJsonProperties(jo.Properties),
+					})
+				}
+				tm.ObjectGroups = append(tm.ObjectGroups, group)
+			case "group":
+				addLayers(layer.Layers)
+			}
+		}
+	}
+	addLayers(jm.Layers)
+	return tm, nil
+}
+
+// tiledJsonProperties reads both array of {name, value}
+// and old map form.
+func tiledJsonProperties(v interface{}) map[string]string {
+	props := make(map[string]string)
+	switch pp := v.(type) {
+	case []interface{}:
+		for _, p := range pp {
+			prop, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := prop["name"].(string)
+			props[name] = fmt.Sprint(prop["value"])
+		}
+	case map[string]interface{}:
+		for name, value := range pp {
+			props[name] = fmt.Sprint(value)
+		}
+	}
+	return props
+}
+
+type tiledTmxProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type tiledTmxPoints struct {
+	Points string `xml:"points,attr"`
+}
+
+type tiledTmxObject struct {
+	Id         int                `xml:"id,attr"`
+	Name       string             `xml:"name,attr"`
+	Type       string             `xml:"type,attr"`
+	X          float32            `xml:"x,attr"`
+	Y          float32            `xml:"y,attr"`
+	Width      float32            `xml:"width,attr"`
+	Height     float32            `xml:"height,attr"`
+	Rotation   float32            `xml:"rotation,attr"`
+	Point      *struct{}          `xml:"point"`
+	Ellipse    *struct{}          `xml:"ellipse"`
+	Polygon    *tiledTmxPoints    `xml:"polygon"`
+	Polyline   *tiledTmxPoints    `xml:"polyline"`
+	Properties []tiledTmxProperty `xml:"properties>property"`
+}
+
+// layer, objectgroup or group, children kept in file order.
+type tiledTmxLayer struct {
+	XMLName xml.Name
+	Name    string            `xml:"name,attr"`
+	Objects []*tiledTmxObject `xml:"object"`
+	// of group
+	Layers []*tiledTmxLayer `xml:",any"`
+}
+
+type tiledTmxMap struct {
+	Orientation string `xml:"orientation,attr"`
+	Width       int    `xml:"width,attr"`
+	Height      int    `xml:"height,attr"`
+	TileWidth   int    `xml:"tilewidth,attr"`
+	TileHeight  int    `xml:"tileheight,attr"`
+	Tilesets    []struct {
+		FirstGid int    `xml:"firstgid,attr"`
+		Name     string `xml:"name,attr"`
+		Source   string `xml:"source,attr"`
+		Image    struct {
+			Source string `xml:"source,attr"`
+		} `xml:"image"`
+	} `xml:"tileset"`
+	Layers []*tiledTmxLayer `xml:",any"`
+}
+
+func parseTiledTmx(dat []byte) (*TiledMap, error) {
+	xm := &tiledTmxMap{}
+	if err := xml.Unmarshal(dat, xm); err != nil {
+		return nil, err
+	}
+	tm := &TiledMap{
+		Orientation: xm.Orientation,
+		Width:       xm.Width,
+		Height:      xm.Height,
+		TileWidth:   xm.TileWidth,
+		TileHeight:  xm.TileHeight,
+	}
+	for _, ts := range xm.Tilesets {
+		tm.Tilesets = append(tm.Tilesets, &TiledTileset{
+			FirstGid: ts.FirstGid,
+			Name:     ts.Name,
+			Image:    ts.Image.Source,
+			Source:   ts.Source,
+		})
+	}
+	var addLayers func(layers []*tiledTmxLayer) error
+	addLayers = func(layers []*tiledTmxLayer) error {
+		for _, layer := range layers {
+			switch layer.XMLName.Local {
+			case "layer":
+				tm.TileLayers = append(tm.TileLayers, layer.Name)
+			case "objectgroup":
+				group, err := parseTiledTmxObjectGroup(layer)
+				if err != nil {
+					return err
+				}
+				tm.ObjectGroups = append(tm.ObjectGroups, group)
+			case "group":
+				if err := addLayers(layer.Layers); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := addLayers(xm.Layers); err != nil {
+		return nil, err
+	}
+	return tm, nil
+}
+
+func parseTiledTmxObjectGroup(xg *tiledTmxLayer) (*TiledObjectGroup, error) {
+	group := &TiledObjectGroup{Name: xg.Name}
+	for _, xo := range xg.Objects {
+		obj := &TiledObject{
+			Id:         xo.Id,
+			Name:       xo.Name,
+			Type:       xo.Type,
+			X:          xo.X,
+			Y:          xo.Y,
+			Width:      xo.Width,
+			Height:     xo.Height,
+			Rotation:   xo.Rotation,
+			Point:      xo.Point != nil,
+			Ellipse:    xo.Ellipse != nil,
+			Properties: make(map[string]string, len(xo.Properties)),
+		}
+		var err error
+		if xo.Polygon != nil {
+			if obj.Polygon, err = parseTiledPoints(xo.Polygon.Points); err != nil {
+				return nil, err
+			}
+		}
+		if xo.Polyline != nil {
+			if obj.Polyline, err = parseTiledPoints(xo.Polyline.Points); err != nil {
+				return nil, err
+			}
+		}
+		for _, prop := range xo.Properties {
+			obj.Properties[prop.Name] = prop.Value
+		}
+		group.Objects = append(group.Objects, obj)
+	}
+	return group, nil
+}
+
+// parseTiledPoints parses "x,y x,y ...".
+func parseTiledPoints(s string) ([]SceneVect, error) {
+	fields := strings.Fields(s)
+	points := make([]SceneVect, len(fields))
+	for i, field := range fields {
+		xy := strings.Split(field, ",")
+		if len(xy) != 2 {
+			return nil, errors.New("invalid points " + strconv.Quote(s))
+		}
+		x, err := strconv.ParseFloat(xy[0], 32)
+		if err != nil {
+			return nil, err
+		}
+		y, err := strconv.ParseFloat(xy[1], 32)
+		if err != nil {
+			return nil, err
+		}
+		points[i] = SceneVect{float32(x), float32(y)}
+	}
+	return points, nil
+}
+
+// tiledToScene converts pixel position of tiled, origin at top left
+// and y down, to scene position, origin at center and y up.
+func tiledToScene(tm *TiledMap, x float32, y float32) SceneVect {
+	w, h := tm.PixelSize()
+	return SceneVect{x - w/2, h/2 - y}
+}
+
+// points of obj in scene, rotated around obj position as tiled does.
+func (obj *TiledObject) scenePoints(tm *TiledMap, local []SceneVect) []SceneVect {
+	rad := float64(obj.Rotation) * math.Pi / 180
+	sin, cos := float32(math.Sin(rad)), float32(math.Cos(rad))
+	points := make([]SceneVect, len(local))
+	for i, p := range local {
+		points[i] = tiledToScene(tm,
+			obj.X+p.X*cos-p.Y*sin,
+			obj.Y+p.X*sin+p.Y*cos)
+	}
+	return points
+}
+
+func (obj *TiledObject) isRect() bool {
+	return !obj.Point && !obj.Ellipse && obj.Polygon == nil && obj.Polyline == nil
+}
+
+// center of rect, position of others.
+func (obj *TiledObject) scenePosition(tm *TiledMap) SceneVect {
+	if obj.isRect() {
+		return obj.scenePoints(tm, []SceneVect{{obj.Width / 2, obj.Height / 2}})[0]
+	}
+	return tiledToScene(tm, obj.X, obj.Y)
+}
+
+func (obj *TiledObject) label() string {
+	if obj.Name != "" {
+		return fmt.Sprintf("object %d %s", obj.Id, obj.Name)
+	}
+	return fmt.Sprintf("object %d", obj.Id)
+}
+
+// applyTiledMap fills def from its map, returns problems found.
+func (def *SceneDef) applyTiledMap(tm *TiledMap) (msgs []string) {
+	fail := func(group string, obj *TiledObject, format string, a ...interface{}) {
+		msgs = append(msgs, group+" "+obj.label()+": "+fmt.Sprintf(format, a...))
+	}
+	def.tiled = tm
+	def.Width, def.Height = tm.PixelSize()
+	for _, group := range tm.ObjectGroups {
+		for _, obj := range group.Objects {
+			switch group.Name {
+			case TiledLayerCollision:
+				switch {
+				case obj.Polyline != nil:
+					points := obj.scenePoints(tm, obj.Polyline)
+					for i := 1; i < len(points); i++ {
+						def.Segments = append(def.Segments, &SceneSegmentDef{
+							A: points[i-1],
+							B: points[i],
+						})
+					}
+				case obj.Polygon != nil:
+					def.Polygons = append(def.Polygons, &ScenePolygonDef{
+						Verts: obj.scenePoints(tm, obj.Polygon),
+					})
+				case obj.isRect():
+					def.Polygons = append(def.Polygons, &ScenePolygonDef{
+						Verts: obj.scenePoints(tm, []SceneVect{
+							{0, 0},
+							{obj.Width, 0},
+							{obj.Width, obj.Height},
+							{0, obj.Height},
+						}),
+					})
+				default:
+					fail(group.Name, obj, "only rectangle, polygon and polyline collide")
+				}
+			case TiledLayerSpawns:
+				pos := obj.scenePosition(tm)
+				def.SpawnPoints = append(def.SpawnPoints, &SceneSpawnPoint{
+					Name: obj.Name,
+					X:    pos.X,
+					Y:    pos.Y,
+				})
+			case TiledLayerPortals:
+				if !obj.isRect() {
+					fail(group.Name, obj, "portal must be a rectangle")
+					continue
+				}
+				pos := obj.scenePosition(tm)
+				portal := &ScenePortalDef{
+					Name:        obj.Name,
+					X:           pos.X,
+					Y:           pos.Y,
+					Width:       obj.Width,
+					Height:      obj.Height,
+					TargetScene: obj.Properties["targetScene"],
+					TargetSpawn: obj.Properties["targetSpawn"],
+				}
+				for _, name := range []string{"minLevel", "questId", "cooldownSeconds"} {
+					prop, ok := obj.Properties[name]
+					if !ok {
+						continue
+					}
+					v, err := strconv.Atoi(prop)
+					if err != nil {
+						fail(group.Name, obj, "property %s must be int", name)
+						continue
+					}
+					switch name {
+					case "minLevel":
+						portal.MinLevel = v
+					case "questId":
+						portal.QuestId = v
+					case "cooldownSeconds":
+						portal.CooldownSeconds = v
+					}
+				}
+				for _, name := range []string{"questComplete", "requireParty"} {
+					prop, ok := obj.Properties[name]
+					if !ok {
+						continue
+					}
+					v, err := strconv.ParseBool(prop)
+					if err != nil {
+						fail(group.Name, obj, "property %s must be bool", name)
+						continue
+					}
+					if name == "questComplete" {
+						portal.QuestComplete = v
+					} else {
+						portal.RequireParty = v
+					}
+				}
+				def.Portals = append(def.Portals, portal)
+			case TiledLayerNpcs:
+				baseId, err := strconv.Atoi(obj.Properties["baseId"])
+				if err != nil {
+					fail(group.Name, obj, "needs int property baseId")
+					continue
+				}
+				pos := obj.scenePosition(tm)
+				def.Npcs = append(def.Npcs, &SceneNpcDef{
+					BaseId: baseId,
+					X:      pos.X,
+					Y:      pos.Y,
+				})
+			}
+		}
+	}
+	return
+}
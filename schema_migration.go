@@ -0,0 +1,222 @@
+package dao
+
+import (
+	"errors"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+)
+
+// SchemaMigration upgrades stored documents from Version-1 to Version.
+// Account gets the account document without chars migrated,
+// Char gets each char document, both may be nil.
+type SchemaMigration struct {
+	Version int
+	Name    string
+	Account func(doc bson.M) error
+	Char    func(doc bson.M) error
+}
+
+var schemaMigrations = []*SchemaMigration{
+	{
+		Version: 1,
+		Name:    "fill defaults of unversioned chars",
+		Char: func(doc bson.M) error {
+			if doc["hotKeys"] == nil {
+				doc["hotKeys"] = NewCharHotKeys()
+			}
+			if doc["learnedSkills"] == nil {
+				doc["learnedSkills"] = bson.M{}
+			}
+			if doc["bodyShape"] == nil {
+				doc["bodyShape"] = &CircleShape{32}
+			}
+			if doc["lastScene"] == nil {
+				doc["lastScene"] = &SceneInfo{"daoCity", 0.0, 0.0}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "assign item instance ids",
+		Char: func(doc bson.M) error {
+			if items, ok := asDoc(doc["items"]); ok {
+				for _, iType := range []string{"equipment", "etcItem", "useSelfItem"} {
+					assignItemInstanceIds(items[iType])
+				}
+			}
+			assignItemInstanceIds(doc["usingEquips"])
+			return nil
+		},
+	},
+}
+
+func asDoc(v interface{}) (bson.M, bool) {
+	switch d := v.(type) {
+	case bson.M:
+		return d, true
+	case map[string]interface{}:
+		return bson.M(d), true
+	}
+	return nil, false
+}
+
+// assignItemInstanceIds gives every item of slots an id if it has none.
+func assignItemInstanceIds(slots interface{}) {
+	list, _ := slots.([]interface{})
+	for _, slot := range list {
+		slotDoc, ok := asDoc(slot)
+		if !ok {
+			continue
+		}
+		itemDoc, ok := asDoc(slotDoc["item"])
+		if !ok {
+			continue
+		}
+		if id, _ := itemDoc["instanceId"].(bson.ObjectId); id == "" {
+			itemDoc["instanceId"] = bson.NewObjectId()
+		}
+	}
+}
+
+// RegisterSchemaMigration adds migration m,
+// its Version must be the next one of CurrentSchemaVersion.
+func RegisterSchemaMigration(m *SchemaMigration) error {
+	if m.Version != CurrentSchemaVersion()+1 {
+		return errors.New("schema migration: version " +
+			strconv.Itoa(m.Version) + " out of order")
+	}
+	schemaMigrations = append(schemaMigrations, m)
+	return nil
+}
+
+func CurrentSchemaVersion() int {
+	if len(schemaMigrations) == 0 {
+		return 0
+	}
+	return schemaMigrations[len(schemaMigrations)-1].Version
+}
+
+func docSchemaVersion(doc bson.M) int {
+	switch v := doc["schemaVersion"].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+func migrateDoc(doc bson.M, isChar bool) (changed bool, err error) {
+	from := docSchemaVersion(doc)
+	for _, m := range schemaMigrations {
+		if m.Version <= from {
+			continue
+		}
+		migrate := m.Account
+		if isChar {
+			migrate = m.Char
+		}
+		if migrate != nil {
+			if err = migrate(doc); err != nil {
+				return
+			}
+		}
+		doc["schemaVersion"] = m.Version
+		changed = true
+	}
+	if from > CurrentSchemaVersion() {
+		err = errors.New("schema migration: document version " +
+			strconv.Itoa(from) + " newer than server")
+	}
+	return
+}
+
+// MigrateAccountDoc upgrades account doc and its chars in place.
+// chars have own version, they may be saved alone.
+func MigrateAccountDoc(doc bson.M) (changed bool, err error) {
+	changed, err = migrateDoc(doc, false)
+	if err != nil {
+		return
+	}
+	chars, _ := doc["chars"].([]interface{})
+	for i, c := range chars {
+		var charDoc bson.M
+		switch cd := c.(type) {
+		case bson.M:
+			charDoc = cd
+		case map[string]interface{}:
+			charDoc = bson.M(cd)
+			chars[i] = charDoc
+		default:
+			continue
+		}
+		charChanged, cerr := migrateDoc(charDoc, true)
+		if cerr != nil {
+			return changed, cerr
+		}
+		changed = changed || charChanged
+	}
+	return
+}
+
+// DecodeAccountDoc migrates doc then decodes it.
+func DecodeAccountDoc(doc bson.M) (*AccountDumpDB, error) {
+	if _, err := MigrateAccountDoc(doc); err != nil {
+		return nil, err
+	}
+	dat, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	dump := &AccountDumpDB{}
+	if err := bson.Unmarshal(dat, dump); err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+type MigrateStorageResult struct {
+	Scanned  int
+	Migrated int
+}
+
+// MigrateStorage upgrades all stored accounts, used offline.
+func MigrateStorage(s Storage) (result MigrateStorageResult, err error) {
+	usernames, err := s.AccountUsernames()
+	if err != nil {
+		return
+	}
+	for _, username := range usernames {
+		var doc bson.M
+		doc, err = s.FindAccountDoc(username)
+		if err == ErrStorageNotFound {
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+		result.Scanned += 1
+		var changed bool
+		changed, err = MigrateAccountDoc(doc)
+		if err != nil {
+			err = errors.New(username + ": " + err.Error())
+			return
+		}
+		if !changed {
+			continue
+		}
+		var dump *AccountDumpDB
+		dump, err = DecodeAccountDoc(doc)
+		if err != nil {
+			return
+		}
+		if err = s.SaveAccount(dump); err != nil {
+			return
+		}
+		result.Migrated += 1
+	}
+	return
+}
@@ -1,7 +1,6 @@
 package dao
 
 import (
-	"encoding/json"
 	"flag"
 	"github.com/go-martini/martini"
 	"github.com/gorilla/websocket"
@@ -16,6 +15,8 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,6 +27,22 @@ type wsConn struct {
 	account         *Account
 	readQuit        chan struct{}
 	sendClientCalls chan []*ClientCall
+	codec           ClientCallCodec
+	// backpressure
+	overflowMutex sync.Mutex
+	overflow      []*ClientCall
+	slowClosed    bool
+	stats         *WsConnStats
+	// account moved to another conn
+	resumed bool
+	// wait for tick end
+	batchMutex sync.Mutex
+	batch      []*ClientCall
+	// nil until client sent World.Hello
+	hello   *ClientHello
+	closing bool
+	// kicked by rate limit, never resumable
+	kicked bool
 }
 
 func (conn *wsConn) write(mt int, msg []byte) error {
@@ -33,9 +50,12 @@ func (conn *wsConn) write(mt int, msg []byte) error {
 	return conn.ws.WriteMessage(mt, msg)
 }
 
-func (conn *wsConn) writeJSON(mt int, v interface{}) error {
-	conn.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	return conn.ws.WriteJSON(v)
+func (conn *wsConn) writeClientCalls(clientCalls []*ClientCall) error {
+	msg, err := conn.codec.Marshal(clientCalls)
+	if err != nil {
+		return err
+	}
+	return conn.write(conn.codec.MessageType(), msg)
 }
 
 func (conn *wsConn) writeRun() {
@@ -51,14 +71,17 @@ func (conn *wsConn) writeRun() {
 				return
 			}
 		case clientCalls, ok := <-conn.sendClientCalls:
-			if !ok {
+			// nil is sent by CloseWithClientCalls
+			if !ok || clientCalls == nil {
 				conn.write(websocket.CloseMessage, []byte{})
 				return
 			}
-			err := conn.writeJSON(websocket.TextMessage, clientCalls)
+			err := conn.writeClientCalls(clientCalls)
 			if err != nil {
 				return
 			}
+			incStat(&conn.stats.SentFrames, &conn.hub.stats.SentFrames, 1)
+			conn.flushOverflow()
 		}
 	}
 }
@@ -67,18 +90,64 @@ func (conn *wsConn) Close() {
 	conn.ws.Close()
 }
 
+// last calls go to client before close, skip batch
+// and what still queued.
+func (conn *wsConn) CloseWithClientCalls(msg []*ClientCall) {
+	conn.overflowMutex.Lock()
+	defer conn.overflowMutex.Unlock()
+	if conn.closing || conn.slowClosed {
+		return
+	}
+	conn.closing = true
+	conn.overflow = nil
+	select {
+	case conn.sendClientCalls <- msg:
+	default:
+		conn.ws.Close()
+		return
+	}
+	select {
+	case conn.sendClientCalls <- nil:
+	default:
+		conn.ws.Close()
+	}
+}
+
 func (conn *wsConn) SendClientCall(msg ...*ClientCall) {
-	conn.sendClientCalls <- msg
-	return
+	conn.SendClientCalls(msg)
 }
 
+// never block, it is called in world loop and scene workers.
 func (conn *wsConn) SendClientCalls(msg []*ClientCall) {
-	conn.sendClientCalls <- msg
-	return
+	if conn.server.configs.ServerConfigs.BatchClientCalls {
+		conn.batchClientCalls(msg)
+		return
+	}
+	conn.enqueueClientCalls(msg)
+}
+
+func (conn *wsConn) enqueueClientCalls(msg []*ClientCall) {
+	if len(msg) == 0 {
+		return
+	}
+	conn.overflowMutex.Lock()
+	defer conn.overflowMutex.Unlock()
+	if conn.slowClosed || conn.resumed || conn.closing {
+		return
+	}
+	if len(conn.overflow) == 0 {
+		select {
+		case conn.sendClientCalls <- msg:
+			return
+		default:
+		}
+	}
+	conn.onSendQueueFull(msg)
 }
 
 func (conn *wsConn) readRun() {
 	defer func() {
+		close(conn.readQuit)
 		conn.hub.unregister <- conn
 	}()
 	conn.ws.SetReadLimit(20480)
@@ -88,16 +157,43 @@ func (conn *wsConn) readRun() {
 		return nil
 	}
 	conn.ws.SetPongHandler(pongFunc)
+	limiter := NewConnRateLimiter(
+		conn.server.configs.ServerConfigs.RateLimit,
+		conn.hub.rateLimitStats)
 	for {
+		_, msg, err := conn.ws.ReadMessage()
+		if err != nil {
+			return
+		}
 		clientCall := &ClientCall{}
-		err := conn.ws.ReadJSON(clientCall)
+		err = conn.codec.Unmarshal(msg, clientCall)
 		if err != nil {
-			switch err.(type) {
-			case *json.InvalidUnmarshalError:
-				continue
-			default:
-				return
-			}
+			continue
+		}
+		action := limiter.Check(clientCall)
+		if action != RateLimitAllow && clientCall.Id != 0 {
+			conn.SendClientCall(NewClientCallError(clientCall,
+				ClientCallErrRateLimited, "too many calls").ClientCall())
+		}
+		switch action {
+		case RateLimitDrop:
+			continue
+		case RateLimitWarn:
+			conn.SendClientCall(&ClientCall{
+				Receiver: "world",
+				Method:   "handleRateLimitWarning",
+				Params: []interface{}{map[string]interface{}{
+					"receiver":   clientCall.Receiver,
+					"method":     clientCall.Method,
+					"violations": limiter.Violations(),
+				}},
+			})
+			continue
+		case RateLimitKick:
+			log.Println("kick flooding connection:", conn.ws.RemoteAddr())
+			// set before unregister, world reads it on detach
+			conn.kicked = true
+			return
 		}
 		conn.server.world.RequestParseClientCall(clientCall, conn)
 	}
@@ -110,6 +206,10 @@ type WsHub struct {
 	register    chan *wsConn
 	unregister  chan *wsConn
 	Quit        chan struct{}
+	stats       *WsHubStats
+	batches     *wsBatchSet
+	//
+	rateLimitStats *RateLimitStats
 }
 
 func (hub *WsHub) Run() {
@@ -117,10 +217,12 @@ func (hub *WsHub) Run() {
 		select {
 		case conn := <-hub.register:
 			hub.connections[conn] = struct{}{}
+			atomic.StoreInt64(&hub.stats.Connections, int64(len(hub.connections)))
 		case conn := <-hub.unregister:
 			delete(hub.connections, conn)
+			atomic.StoreInt64(&hub.stats.Connections, int64(len(hub.connections)))
 			if conn.account != nil {
-				conn.account.RequestLogout()
+				conn.account.RequestDetach(conn)
 			} else {
 				conn.ws.Close()
 			}
@@ -133,7 +235,7 @@ func (hub *WsHub) Run() {
 
 type Server struct {
 	world            *World
-	db               *DaoDB
+	db               Storage
 	wsHub            *WsHub
 	configs          *DaoConfigs
 	commandLineFlags *ServerCommandLineFlags
@@ -146,6 +248,7 @@ type ServerCommandLineFlags struct {
 	ConfigDirPath     string
 	MongodbURL        string
 	MongodbDBName     string
+	StorageBackend    string
 	ProductionMode    bool
 }
 
@@ -163,6 +266,8 @@ func (s *Server) parseCommandLineFlags() {
 		"127.0.0.1", "MongoDB URL.")
 	flag.StringVar(&scFlags.MongodbDBName, "mongodbDBName",
 		"dao", "MongoDB db name.")
+	flag.StringVar(&scFlags.StorageBackend, "storage",
+		"", "Storage backend, mongo, bolt or memory.")
 	// TODO
 	// production mode not imple!
 	flag.BoolVar(&scFlags.ProductionMode, "production",
@@ -187,6 +292,9 @@ func (s *Server) useCommandLienFlags() {
 		}
 		s.configs.MongoDBConfigs.DBName = scFlags.MongodbDBName
 	}
+	if scFlags.StorageBackend != "" {
+		s.configs.StorageConfigs.Backend = scFlags.StorageBackend
+	}
 }
 
 func NewServer() (ds *Server, err error) {
@@ -203,12 +311,11 @@ func NewServer() (ds *Server, err error) {
 	}
 	ds.configs.LoadConfigFiles()
 	ds.useCommandLienFlags()
-	ds.db, err = NewDaoDB(ds.configs.MongoDBConfigs.URL,
-		ds.configs.MongoDBConfigs.DBName)
+	ds.db, err = NewStorage(ds.configs)
 	if err != nil {
 		panic(err)
 	}
-	ds.world, err = NewWorld(ds.db.CloneSession(), ds.configs)
+	ds.world, err = NewWorld(ds.db.Clone(), ds.configs)
 	if err != nil {
 		panic(err)
 	}
@@ -219,9 +326,15 @@ func NewServer() (ds *Server, err error) {
 		wsUpgrader: &websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
+			Subprotocols:    ClientCallCodecNames,
 			CheckOrigin:     func(r *http.Request) bool { return true },
+			// permessage-deflate, only when client offers it.
+			EnableCompression: ds.configs.ServerConfigs.EnableCompression,
 		},
-		Quit: make(chan struct{}),
+		Quit:           make(chan struct{}),
+		stats:          &WsHubStats{},
+		batches:        newWsBatchSet(),
+		rateLimitStats: NewRateLimitStats(),
 	}
 	ds.world.server = ds
 	ds.wsHub.server = ds
@@ -247,18 +360,23 @@ func serveWs(w http.ResponseWriter, r *http.Request, ds *Server) {
 		return
 	}
 	conn := NewWsConn(ws, ds.wsHub)
+	ws.EnableWriteCompression(ds.configs.ServerConfigs.EnableCompression)
 	ds.wsHub.register <- conn
 	go conn.writeRun()
 	conn.readRun()
 }
 
 func NewWsConn(ws *websocket.Conn, hub *WsHub) *wsConn {
+	queueSize := hub.server.configs.ServerConfigs.SendQueueSize
 	return &wsConn{
 		ws:              ws,
 		hub:             hub,
 		server:          hub.server,
 		account:         nil,
-		sendClientCalls: make(chan []*ClientCall, 256),
+		readQuit:        make(chan struct{}),
+		sendClientCalls: make(chan []*ClientCall, queueSize),
+		codec:           ClientCallCodecByName(ws.Subprotocol()),
+		stats:           &WsConnStats{},
 	}
 }
 
@@ -285,6 +403,10 @@ func (s *Server) RunWeb() {
 	m.Map(s.world)
 	m.Map(s)
 	m.Use(gzip.All())
+	m.Use(martini.Static(TiledMapDir, martini.StaticOptions{
+		Prefix:      TiledMapUrlPrefix,
+		SkipLogging: true,
+	}))
 	m.Use(sessions.Sessions("_auth", store))
 	m.Use(s.DBHandler())
 	m.Use(render.Renderer())
@@ -322,8 +444,11 @@ func (s *Server) RunWeb() {
 	m.Get("/account/isLogined", haldeAccountIsLogined)
 	// sync client
 	m.Get("/clientVersion", handleClientVersion)
+	m.Get("/protocolSchema", handleProtocolSchema)
 	// websocket port
 	m.Get("/websocketPort", handleWebsocketPort)
+	// stats
+	m.Get("/serverStats", handleServerStats)
 	// server run
 	httpPort := s.configs.ServerConfigs.HttpPort
 	wsPort := s.configs.ServerConfigs.WebsocketPort
@@ -336,6 +461,24 @@ func (s *Server) RunWeb() {
 	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(httpPort), m))
 }
 
+type ServerStats struct {
+	WsHub     WsHubStats            `json:"wsHub"`
+	RateLimit *RateLimitStatsClient `json:"rateLimit"`
+	WorldTick *WorldTickStatsClient `json:"worldTick"`
+	Persist   PersistQueueStats     `json:"persist"`
+	Ledger    LedgerStats           `json:"ledger"`
+}
+
+func (s *Server) Stats() *ServerStats {
+	return &ServerStats{
+		WsHub:     s.wsHub.stats.Snapshot(),
+		RateLimit: s.wsHub.rateLimitStats.Client(),
+		WorldTick: s.world.TickStats().Client(),
+		Persist:   s.world.PersistQueue().Stats(),
+		Ledger:    s.world.Ledger().Stats(),
+	}
+}
+
 func (s *Server) run() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
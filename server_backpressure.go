@@ -0,0 +1,222 @@
+package dao
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// what to do when a connection's send queue is full.
+const (
+	SlowClientCoalesce   = "coalesce"
+	SlowClientDropOldest = "dropOldest"
+	SlowClientDisconnect = "disconnect"
+)
+
+// calls that only describe newest state, client will
+// get a newer one soon, so can be merged or dropped.
+// handleViewSnapshot is not one, later deltas diff with it.
+var nonCriticalClientCallMethods = map[string]struct{}{
+	"handleMoveStateChange": struct{}{},
+	"handleUpdateBioConfig": struct{}{},
+	"handleUpdateCpBody":    struct{}{},
+}
+
+func IsCriticalClientCall(c *ClientCall) bool {
+	_, ok := nonCriticalClientCallMethods[c.Method]
+	return !ok
+}
+
+func clientCallCoalesceKey(c *ClientCall) (string, bool) {
+	if IsCriticalClientCall(c) {
+		return "", false
+	}
+	if len(c.Params) > 0 {
+		if id, ok := c.Params[0].(int); ok {
+			return c.Method + ":" + strconv.Itoa(id), true
+		}
+	}
+	return c.Method, true
+}
+
+// mergeClientCallState merges partial state map of newer into older's,
+// like {hp, mp} then {hp}, newer fields win. Calls may be shared
+// by conns, so a new one is returned.
+func mergeClientCallState(older *ClientCall, newer *ClientCall) *ClientCall {
+	if len(older.Params) < 2 || len(newer.Params) < 2 {
+		return newer
+	}
+	var state interface{}
+	switch newState := newer.Params[1].(type) {
+	case map[string]int:
+		oldState, ok := older.Params[1].(map[string]int)
+		if !ok {
+			return newer
+		}
+		merged := make(map[string]int, len(oldState)+len(newState))
+		for k, v := range oldState {
+			merged[k] = v
+		}
+		for k, v := range newState {
+			merged[k] = v
+		}
+		state = merged
+	case map[string]float32:
+		oldState, ok := older.Params[1].(map[string]float32)
+		if !ok {
+			return newer
+		}
+		merged := make(map[string]float32, len(oldState)+len(newState))
+		for k, v := range oldState {
+			merged[k] = v
+		}
+		for k, v := range newState {
+			merged[k] = v
+		}
+		state = merged
+	default:
+		return newer
+	}
+	params := make([]interface{}, len(newer.Params))
+	copy(params, newer.Params)
+	params[1] = state
+	return &ClientCall{
+		Receiver: newer.Receiver,
+		Method:   newer.Method,
+		Params:   params,
+		Id:       newer.Id,
+	}
+}
+
+type WsConnStats struct {
+	SentFrames     int64 `json:"sentFrames"`
+	OverflowCalls  int64 `json:"overflowCalls"`
+	CoalescedCalls int64 `json:"coalescedCalls"`
+	DroppedCalls   int64 `json:"droppedCalls"`
+	BatchedCalls   int64 `json:"batchedCalls"`
+}
+
+type WsHubStats struct {
+	WsConnStats
+	Connections           int64 `json:"connections"`
+	SlowClientDisconnects int64 `json:"slowClientDisconnects"`
+	BatchFlushes          int64 `json:"batchFlushes"`
+}
+
+func (s *WsConnStats) Snapshot() WsConnStats {
+	return WsConnStats{
+		SentFrames:     atomic.LoadInt64(&s.SentFrames),
+		OverflowCalls:  atomic.LoadInt64(&s.OverflowCalls),
+		CoalescedCalls: atomic.LoadInt64(&s.CoalescedCalls),
+		DroppedCalls:   atomic.LoadInt64(&s.DroppedCalls),
+		BatchedCalls:   atomic.LoadInt64(&s.BatchedCalls),
+	}
+}
+
+func (s *WsHubStats) Snapshot() WsHubStats {
+	return WsHubStats{
+		WsConnStats:           s.WsConnStats.Snapshot(),
+		Connections:           atomic.LoadInt64(&s.Connections),
+		SlowClientDisconnects: atomic.LoadInt64(&s.SlowClientDisconnects),
+		BatchFlushes:          atomic.LoadInt64(&s.BatchFlushes),
+	}
+}
+
+func incStat(connStat *int64, hubStat *int64, n int64) {
+	atomic.AddInt64(connStat, n)
+	atomic.AddInt64(hubStat, n)
+}
+
+// must hold overflowMutex.
+func (conn *wsConn) onSendQueueFull(msg []*ClientCall) {
+	configs := conn.server.configs.ServerConfigs
+	conn.overflow = append(conn.overflow, msg...)
+	incStat(&conn.stats.OverflowCalls, &conn.hub.stats.OverflowCalls, int64(len(msg)))
+	switch configs.SlowClientPolicy {
+	case SlowClientCoalesce:
+		conn.coalesceOverflow()
+	case SlowClientDropOldest:
+		conn.dropOldestOverflow(configs.SlowClientThreshold)
+	}
+	if len(conn.overflow) > configs.SlowClientThreshold {
+		conn.closeBySlowClient()
+	}
+}
+
+func (conn *wsConn) coalesceOverflow() {
+	lastIndex := make(map[string]int, len(conn.overflow))
+	states := make(map[string]*ClientCall, len(conn.overflow))
+	for i, c := range conn.overflow {
+		key, ok := clientCallCoalesceKey(c)
+		if !ok {
+			continue
+		}
+		lastIndex[key] = i
+		if older, found := states[key]; found {
+			states[key] = mergeClientCallState(older, c)
+		} else {
+			states[key] = c
+		}
+	}
+	merged := conn.overflow[:0]
+	for i, c := range conn.overflow {
+		key, ok := clientCallCoalesceKey(c)
+		if ok {
+			if lastIndex[key] != i {
+				continue
+			}
+			c = states[key]
+		}
+		merged = append(merged, c)
+	}
+	n := len(conn.overflow) - len(merged)
+	if n > 0 {
+		incStat(&conn.stats.CoalescedCalls, &conn.hub.stats.CoalescedCalls, int64(n))
+	}
+	conn.overflow = merged
+}
+
+func (conn *wsConn) dropOldestOverflow(threshold int) {
+	over := len(conn.overflow) - threshold
+	if over <= 0 {
+		return
+	}
+	kept := conn.overflow[:0]
+	for _, c := range conn.overflow {
+		if over > 0 && !IsCriticalClientCall(c) {
+			over -= 1
+			continue
+		}
+		kept = append(kept, c)
+	}
+	n := len(conn.overflow) - len(kept)
+	incStat(&conn.stats.DroppedCalls, &conn.hub.stats.DroppedCalls, int64(n))
+	conn.overflow = kept
+}
+
+// called by writeRun after each frame, overflowed calls
+// go to client as one frame.
+func (conn *wsConn) flushOverflow() {
+	conn.overflowMutex.Lock()
+	defer conn.overflowMutex.Unlock()
+	if len(conn.overflow) == 0 {
+		return
+	}
+	select {
+	case conn.sendClientCalls <- conn.overflow:
+		conn.overflow = nil
+	default:
+	}
+}
+
+// readRun will fail and unregister conn from hub,
+// so never block world loop here.
+// must hold overflowMutex.
+func (conn *wsConn) closeBySlowClient() {
+	if conn.slowClosed {
+		return
+	}
+	conn.slowClosed = true
+	conn.overflow = nil
+	atomic.AddInt64(&conn.hub.stats.SlowClientDisconnects, 1)
+	conn.ws.Close()
+}
@@ -0,0 +1,101 @@
+package dao
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	ClientCallPriorityHigh = iota
+	ClientCallPriorityNormal
+)
+
+// replies client waiting for, not tied to scene state,
+// so safe to move ahead in a frame.
+var highPriorityClientCallMethods = map[string]struct{}{
+	"handleClientCallError":  struct{}{},
+	"handleRateLimitWarning": struct{}{},
+	"handlePong":             struct{}{},
+}
+
+func ClientCallPriority(c *ClientCall) int {
+	if _, ok := highPriorityClientCallMethods[c.Method]; ok {
+		return ClientCallPriorityHigh
+	}
+	// scene state calls keep their order, a state change
+	// must not land after handleRemoveById of the same id.
+	return ClientCallPriorityNormal
+}
+
+type clientCallsByPriority []*ClientCall
+
+func (p clientCallsByPriority) Len() int { return len(p) }
+func (p clientCallsByPriority) Less(i, j int) bool {
+	return ClientCallPriority(p[i]) < ClientCallPriority(p[j])
+}
+func (p clientCallsByPriority) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// conns have calls waiting for tick end.
+type wsBatchSet struct {
+	mutex sync.Mutex
+	conns map[*wsConn]struct{}
+}
+
+func newWsBatchSet() *wsBatchSet {
+	return &wsBatchSet{
+		conns: make(map[*wsConn]struct{}),
+	}
+}
+
+func (bs *wsBatchSet) add(conn *wsConn) {
+	bs.mutex.Lock()
+	bs.conns[conn] = struct{}{}
+	bs.mutex.Unlock()
+}
+
+func (bs *wsBatchSet) take() map[*wsConn]struct{} {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	conns := bs.conns
+	bs.conns = make(map[*wsConn]struct{}, len(conns))
+	return conns
+}
+
+func (conn *wsConn) batchClientCalls(msg []*ClientCall) {
+	conn.batchMutex.Lock()
+	isFirst := len(conn.batch) == 0
+	conn.batch = append(conn.batch, msg...)
+	conn.batchMutex.Unlock()
+	if isFirst {
+		conn.hub.batches.add(conn)
+	}
+}
+
+func (conn *wsConn) takeBatch() []*ClientCall {
+	conn.batchMutex.Lock()
+	defer conn.batchMutex.Unlock()
+	batch := conn.batch
+	conn.batch = nil
+	return batch
+}
+
+// one frame per conn, higher priority first,
+// same priority keep send order.
+func (conn *wsConn) flushBatch() {
+	batch := conn.takeBatch()
+	if len(batch) == 0 {
+		return
+	}
+	sort.Stable(clientCallsByPriority(batch))
+	incStat(&conn.stats.BatchedCalls, &conn.hub.stats.BatchedCalls, int64(len(batch)))
+	conn.enqueueClientCalls(batch)
+}
+
+// called by world at end of each tick.
+func (hub *WsHub) FlushBatches() {
+	for conn, _ := range hub.batches.take() {
+		conn.flushBatch()
+	}
+	atomic.AddInt64(&hub.stats.BatchFlushes, 1)
+}
@@ -10,8 +10,6 @@ import (
 	"github.com/nu7hatch/gouuid"
 	"github.com/xuhaojun/oauth2"
 	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
 	"log"
 	"net/http"
 )
@@ -32,14 +30,14 @@ func (accRegiForm *AccountRegisterFrom) IsVaild() bool {
 
 func (s *Server) DBHandler() martini.Handler {
 	return func(c martini.Context) {
-		dbSessionClone := s.db.CloneSession()
-		c.Map(dbSessionClone)
+		dbSessionClone := s.db.Clone()
+		c.MapTo(dbSessionClone, (*Storage)(nil))
 		defer dbSessionClone.Close()
 		c.Next()
 	}
 }
 
-func handleAccountRegister(form AccountRegisterFrom, db *DaoDB, r render.Render, configs *DaoConfigs) {
+func handleAccountRegister(form AccountRegisterFrom, db Storage, r render.Render, configs *DaoConfigs) {
 	username := form.Username
 	password := form.Password
 	email := form.Email
@@ -53,19 +51,18 @@ func handleAccountRegister(form AccountRegisterFrom, db *DaoDB, r render.Render,
 		r.JSON(200, clientCall)
 		return
 	}
-	queryAcc := bson.M{"username": username}
-	err := db.accounts.Find(queryAcc).Select(bson.M{"_id": 1}).One(&struct{}{})
+	found, err := db.HasAccount(username)
 	var clientCall *ClientCall
-	if err != nil && err != mgo.ErrNotFound {
+	if err != nil {
 		panic(err)
-	} else if err != mgo.ErrNotFound {
+	} else if found {
 		clientErr := []interface{}{"duplicated account!"}
 		clientCall = &ClientCall{
 			Receiver: "world",
 			Method:   "handleErrorLoginAccount",
 			Params:   clientErr,
 		}
-	} else if err == mgo.ErrNotFound {
+	} else {
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
 		if err != nil {
 			panic(err)
@@ -85,7 +82,7 @@ func handleAccountRegister(form AccountRegisterFrom, db *DaoDB, r render.Render,
 	r.JSON(200, clientCall)
 }
 
-func handleAccountInfo(db *DaoDB, session sessions.Session, r render.Render, w *World) {
+func handleAccountInfo(db Storage, session sessions.Session, r render.Render, w *World) {
 	var clientCall *ClientCall
 	username := session.Get("username")
 	if username == nil {
@@ -97,13 +94,11 @@ func handleAccountInfo(db *DaoDB, session sessions.Session, r render.Render, w *
 		r.JSON(200, clientCall)
 		return
 	} else {
-		foundAcc := &AccountDumpDB{}
-		queryAcc := bson.M{"username": username.(string)}
-		err := db.accounts.Find(queryAcc).One(foundAcc)
-		if err != nil && err != mgo.ErrNotFound {
+		foundAcc, err := db.FindAccount(username.(string))
+		if err != nil && err != ErrStorageNotFound {
 			panic(err)
 		}
-		if err == mgo.ErrNotFound || err != nil {
+		if err != nil {
 			clientCall = &ClientCall{
 				Receiver: "world",
 				Method:   "handleWebAccountInfo",
@@ -133,7 +128,7 @@ func handleAccountInfo(db *DaoDB, session sessions.Session, r render.Render, w *
 	r.JSON(200, clientCall)
 }
 
-func handleAccountRegisterByFacebook(db *DaoDB, r render.Render, tokens oauth2.Tokens, configs *DaoConfigs) {
+func handleAccountRegisterByFacebook(db Storage, r render.Render, tokens oauth2.Tokens, configs *DaoConfigs) {
 	url := "https://graph.facebook.com/me?access_token=" + tokens.Access()
 	client := &http.Client{}
 	req, err := http.NewRequest("GET", url, nil)
@@ -161,7 +156,7 @@ func handleAccountRegisterByFacebook(db *DaoDB, r render.Render, tokens oauth2.T
 		db, r, configs)
 }
 
-func handleAccountLoginByFacebook(params martini.Params, db *DaoDB, r render.Render, tokens oauth2.Tokens, session sessions.Session) {
+func handleAccountLoginByFacebook(params martini.Params, db Storage, r render.Render, tokens oauth2.Tokens, session sessions.Session) {
 	ltype := params["ltype"]
 	switch ltype {
 	case "Web":
@@ -173,7 +168,7 @@ func handleAccountLoginByFacebook(params martini.Params, db *DaoDB, r render.Ren
 	}
 }
 
-func handleAccountLoginWebByFacebook(db *DaoDB, r render.Render, tokens oauth2.Tokens, session sessions.Session) {
+func handleAccountLoginWebByFacebook(db Storage, r render.Render, tokens oauth2.Tokens, session sessions.Session) {
 	if tokens.Expired() || tokens.ProviderName() != "Facebook" {
 		r.Redirect("oauth2login?next=#home", 302)
 		return
@@ -211,7 +206,7 @@ func handleAccountLoginWebByFacebook(db *DaoDB, r render.Render, tokens oauth2.T
 	r.JSON(200, clientCall)
 }
 
-func handleAccountLoginGameByFacebook(db *DaoDB, r render.Render, tokens oauth2.Tokens, session sessions.Session) {
+func handleAccountLoginGameByFacebook(db Storage, r render.Render, tokens oauth2.Tokens, session sessions.Session) {
 	if tokens.Expired() || tokens.ProviderName() != "Facebook" {
 		r.Redirect("oauth2login?next=#loginFacebook", 302)
 		return
@@ -254,7 +249,7 @@ type AccountLoginForm struct {
 	Password string `form:"password" binding:"required"`
 }
 
-func handleAccountLogin(params martini.Params, form AccountLoginForm, session sessions.Session, r render.Render, db *DaoDB) {
+func handleAccountLogin(params martini.Params, form AccountLoginForm, session sessions.Session, r render.Render, db Storage) {
 	ltype := params["ltype"]
 	switch ltype {
 	case "Web":
@@ -266,19 +261,18 @@ func handleAccountLogin(params martini.Params, form AccountLoginForm, session se
 	}
 }
 
-func handleAccountLoginWeb(form AccountLoginForm, session sessions.Session, r render.Render, db *DaoDB) {
+func handleAccountLoginWeb(form AccountLoginForm, session sessions.Session, r render.Render, db Storage) {
 	username := form.Username
 	password := form.Password
-	foundAcc := struct {
-		Password string `bson:"password"`
-	}{}
-	queryAcc := bson.M{"username": username}
-	err := db.accounts.Find(queryAcc).Select(bson.M{"password": 1}).One(&foundAcc)
-	if err != nil && err != mgo.ErrNotFound {
+	foundAcc, err := db.FindAccount(username)
+	if err != nil && err != ErrStorageNotFound {
 		panic(err)
 	}
+	if err == ErrStorageNotFound {
+		foundAcc = &AccountDumpDB{}
+	}
 	passwordErr := bcrypt.CompareHashAndPassword([]byte(foundAcc.Password), []byte(password))
-	if err == mgo.ErrNotFound || passwordErr != nil {
+	if err == ErrStorageNotFound || passwordErr != nil {
 		clientErr := []interface{}{"wrong username or password"}
 		clientCall := &ClientCall{
 			Receiver: "world",
@@ -324,19 +318,18 @@ func handleAccountLoginGameBySession(session sessions.Session, r render.Render,
 	r.JSON(200, clientCall)
 }
 
-func handleAccountLoginGame(form AccountLoginForm, session sessions.Session, r render.Render, db *DaoDB) {
+func handleAccountLoginGame(form AccountLoginForm, session sessions.Session, r render.Render, db Storage) {
 	username := form.Username
 	password := form.Password
-	foundAcc := struct {
-		Password string `bson:"password"`
-	}{}
-	queryAcc := bson.M{"username": username}
-	err := db.accounts.Find(queryAcc).Select(bson.M{"password": 1}).One(&foundAcc)
-	if err != nil && err != mgo.ErrNotFound {
+	foundAcc, err := db.FindAccount(username)
+	if err != nil && err != ErrStorageNotFound {
 		panic(err)
 	}
+	if err == ErrStorageNotFound {
+		foundAcc = &AccountDumpDB{}
+	}
 	err = bcrypt.CompareHashAndPassword([]byte(foundAcc.Password), []byte(password))
-	if err == mgo.ErrNotFound || err != nil {
+	if err != nil {
 		clientErr := []interface{}{"wrong username or password"}
 		clientCall := &ClientCall{
 			Receiver: "world",
@@ -389,6 +382,14 @@ func handleClientVersion(r render.Render, s *Server) {
 	r.JSON(200, clientCall)
 }
 
+func handleProtocolSchema(r render.Render, s *Server) {
+	r.JSON(200, s.ProtocolSchema())
+}
+
 func handleWebsocketPort(r render.Render, s *Server) {
 	r.JSON(200, s.configs.ServerConfigs.WebsocketPort)
 }
+
+func handleServerStats(r render.Render, s *Server) {
+	r.JSON(200, s.Stats())
+}
@@ -0,0 +1,204 @@
+package dao
+
+import (
+	"crypto/subtle"
+	"github.com/nu7hatch/gouuid"
+	"sort"
+	"time"
+)
+
+// these are resent by resync, replay old ones may break client's scene.
+var resyncedClientCallMethods = map[string]struct{}{
+	"handleAddScene":     struct{}{},
+	"handleRunScene":     struct{}{},
+	"handleDestroyScene": struct{}{},
+	"handleJoinScene":    struct{}{},
+	"handleLeaveScene":   struct{}{},
+	"handleSetPosition":  struct{}{},
+}
+
+func isReplayableClientCall(c *ClientCall) bool {
+	if !IsCriticalClientCall(c) || c.Receiver == "scene" {
+		return false
+	}
+	_, ok := resyncedClientCallMethods[c.Method]
+	return !ok
+}
+
+func newResumeToken() string {
+	base, err := uuid.NewV4()
+	if err != nil {
+		return ""
+	}
+	return base.String()
+}
+
+func (a *Account) ResumeToken() string {
+	return a.resumeToken
+}
+
+func (a *Account) IsDetached() bool {
+	return a.detached
+}
+
+func (a *Account) CheckResumeToken(token string) bool {
+	if a.resumeToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(a.resumeToken), []byte(token)) == 1
+}
+
+func (a *Account) RequestDetach(sock *wsConn) {
+	a.world.DetachAccount <- sock
+}
+
+// account and char stay in world until grace end,
+// client can use resume token to reattach them.
+func (a *Account) Detach(grace time.Duration) {
+	if a.detached {
+		return
+	}
+	a.detached = true
+	a.detachTimer = a.world.SetTimeout(func() {
+		a.detachTimer = nil
+		w := a.world
+		if a.detached && w.accounts[a.username] == a {
+			w.DoLogoutAccount(a)
+		}
+	}, grace)
+	a.world.logger.Println("Account:", a.username, "detached.")
+}
+
+func (a *Account) Resume(sock *wsConn) {
+	if a.detachTimer != nil {
+		a.world.ClearTimeout(a.detachTimer)
+		a.detachTimer = nil
+	}
+	old := a.sock
+	pending := old.takePendingClientCalls()
+	old.account = nil
+	if !a.detached {
+		// client found socket dead before server.
+		old.Close()
+	}
+	a.detached = false
+	a.sock = sock
+	sock.account = a
+	a.resumeToken = ""
+	if sock.HasFeature(ProtocolFeatureResume) {
+		a.resumeToken = newResumeToken()
+	}
+	clientCalls := make([]*ClientCall, 0, len(pending)+8)
+	clientCalls = append(clientCalls, a.ClientSuccessLoginAccount(true))
+	if a.usingChar != nil {
+		a.usingChar.sock = sock
+		clientCalls = append(clientCalls, a.usingChar.ResyncClientCalls()...)
+	}
+	for _, c := range pending {
+		if isReplayableClientCall(c) {
+			clientCalls = append(clientCalls, c)
+		}
+	}
+	sock.SendClientCalls(clientCalls)
+	if a.usingChar != nil {
+		a.usingChar.ResyncViewAOI()
+	}
+}
+
+func (a *Account) ClientSuccessLoginAccount(resumed bool) *ClientCall {
+	charClients := make([]interface{}, len(a.chars))
+	for i, char := range a.chars {
+		charClients[i] = char.CharClient()
+	}
+	param := map[string]interface{}{
+		"username":    a.username,
+		"charConfigs": charClients,
+		"resumeToken": a.resumeToken,
+		"resumed":     resumed,
+	}
+	return &ClientCall{
+		Receiver: "world",
+		Method:   "handleSuccessLoginAcccount",
+		Params:   []interface{}{param},
+	}
+}
+
+// what client lost when socket dropped, like LoginChar do.
+func (c *Char) ResyncClientCalls() []*ClientCall {
+	accParam := map[string]interface{}{"usingChar": c.slotIndex}
+	clientCalls := []*ClientCall{
+		&ClientCall{
+			Receiver: "account",
+			Method:   "handleSuccessLoginChar",
+			Params:   []interface{}{accParam},
+		},
+	}
+	scene := c.scene
+	if scene == nil {
+		return clientCalls
+	}
+	pos := c.body.Position()
+	clientCalls = append(clientCalls,
+		&ClientCall{
+			Receiver: "world",
+			Method:   "handleAddScene",
+			Params:   []interface{}{scene.SceneClient()},
+		},
+		&ClientCall{
+			Receiver: "world",
+			Method:   "handleRunScene",
+			Params:   []interface{}{scene.name},
+		},
+		&ClientCall{
+			Receiver: "char",
+			Method:   "handleJoinScene",
+			Params: []interface{}{map[string]interface{}{
+				"sceneName": scene.name,
+				"id":        c.id,
+			}},
+		},
+		&ClientCall{
+			Receiver: "char",
+			Method:   "handleSetPosition",
+			Params: []interface{}{map[string]float32{
+				"x": float32(pos.X),
+				"y": float32(pos.Y),
+			}},
+		})
+	return clientCalls
+}
+
+// send everything in view again, snapshots start from full one.
+func (c *Char) ResyncViewAOI() {
+	c.viewSnapshot.Reset()
+	if c.scene == nil {
+		return
+	}
+	enter := c.viewAOIState.OnSceneObjectEnter
+	for sb, _ := range c.viewAOIState.inAreaSceneObjecters {
+		enter(sb)
+	}
+}
+
+// calls not yet written to client, conn will not queue any more.
+func (conn *wsConn) takePendingClientCalls() []*ClientCall {
+	conn.overflowMutex.Lock()
+	defer conn.overflowMutex.Unlock()
+	conn.resumed = true
+	pending := make([]*ClientCall, 0)
+drain:
+	for {
+		select {
+		case calls := <-conn.sendClientCalls:
+			pending = append(pending, calls...)
+		default:
+			break drain
+		}
+	}
+	pending = append(pending, conn.overflow...)
+	conn.overflow = nil
+	// batched for this tick, ordered as flushBatch would.
+	batch := conn.takeBatch()
+	sort.Stable(clientCallsByPriority(batch))
+	return append(pending, batch...)
+}
@@ -9,7 +9,7 @@ type Shop struct {
 }
 
 func (s *Shop) NewItemBySellIndex(i int) Itemer {
-	if s.itemBaseIds == nil || i > len(s.itemBaseIds) {
+	if i < 0 || i >= len(s.itemBaseIds) {
 		return nil
 	}
 	w := s.world
@@ -42,14 +42,10 @@ func (s *Shop) ShopItemsClient() []interface{} {
 	if w == nil {
 		return nil
 	}
+	catalog := w.ItemCatalog()
 	shopItemsClient := make([]interface{}, len(s.itemBaseIds))
 	for i, id := range s.itemBaseIds {
-		item, err := w.NewItemByBaseId(id)
-		if err != nil {
-			shopItemsClient[i] = nil
-		} else {
-			shopItemsClient[i] = item.Client()
-		}
+		shopItemsClient[i] = catalog.ItemClient(id)
 	}
 	return shopItemsClient
 }
@@ -0,0 +1,310 @@
+package dao
+
+import (
+	"encoding/json"
+	"errors"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"sync"
+)
+
+var ErrStorageNotFound = errors.New("storage: not found")
+
+// Storage persists accounts, chars and item templates.
+// Clone returns a handle safe to use from another goroutine,
+// it must be closed by the caller.
+type Storage interface {
+	// FindAccount returns account migrated to current schema.
+	FindAccount(username string) (*AccountDumpDB, error)
+	// FindAccountDoc returns stored account document as is.
+	FindAccountDoc(username string) (bson.M, error)
+	AccountUsernames() ([]string, error)
+	HasAccount(username string) (bool, error)
+	SaveAccount(dump *AccountDumpDB) error
+	SaveChar(accountId bson.ObjectId, slotIndex int, dump *CharDumpDB) error
+	HasCharName(name string) (bool, error)
+	// ImportItems mirrors the item catalog for tools reading
+	// the db directly, world itself only reads ItemCatalog.
+	ImportItems(items []interface{}) error
+	// SaveCharSnapshot keeps newest keep snapshots of the char.
+	SaveCharSnapshot(snap *CharSnapshot, keep int) error
+	// FindCharSnapshots returns snapshots of char, newest first.
+	FindCharSnapshots(charId bson.ObjectId) ([]*CharSnapshot, error)
+	FindCharSnapshot(id bson.ObjectId) (*CharSnapshot, error)
+	// ledger is append only.
+	AppendLedger(entries []*LedgerEntry) error
+	// FindLedger returns matched entries, newest first.
+	FindLedger(q *LedgerQuery) ([]*LedgerEntry, error)
+	UpdateAccountIndex()
+	Clone() Storage
+	Close()
+}
+
+const (
+	StorageBackendMongo  = "mongo"
+	StorageBackendMemory = "memory"
+	StorageBackendBolt   = "bolt"
+)
+
+func NewStorage(configs *DaoConfigs) (Storage, error) {
+	switch configs.StorageConfigs.Backend {
+	case StorageBackendMemory:
+		return NewMemoryStorage(), nil
+	case StorageBackendBolt:
+		return NewBoltStorage(configs.StorageConfigs.BoltPath)
+	case StorageBackendMongo, "":
+		return NewDaoDB(configs.MongoDBConfigs.URL,
+			configs.MongoDBConfigs.DBName)
+	default:
+		return nil, errors.New("storage: unknown backend " +
+			configs.StorageConfigs.Backend)
+	}
+}
+
+func ReadDefaultJsonDB() ([]interface{}, error) {
+	dat, err := ioutil.ReadFile("db/item_db.json")
+	if err != nil {
+		return nil, err
+	}
+	var items []interface{}
+	err = json.Unmarshal(dat, &items)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// MemoryStorage keeps bson encoded copies, so callers never share
+// state with it and it behaves like the mongo one.
+// All clones share the same data.
+type MemoryStorage struct {
+	mu       *sync.RWMutex
+	accounts map[string][]byte
+	// account id to username
+	accountIds map[bson.ObjectId]string
+	items      map[int][]byte
+	// oldest first
+	charSnapshots map[bson.ObjectId][][]byte
+	ledger        []*LedgerEntry
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		mu:         &sync.RWMutex{},
+		accounts:   make(map[string][]byte),
+		accountIds: make(map[bson.ObjectId]string),
+		items:      make(map[int][]byte),
+		//
+		charSnapshots: make(map[bson.ObjectId][][]byte),
+	}
+}
+
+func (m *MemoryStorage) FindAccount(username string) (*AccountDumpDB, error) {
+	doc, err := m.FindAccountDoc(username)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeAccountDoc(doc)
+}
+
+func (m *MemoryStorage) FindAccountDoc(username string) (bson.M, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dat, ok := m.accounts[username]
+	if !ok {
+		return nil, ErrStorageNotFound
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(dat, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (m *MemoryStorage) AccountUsernames() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	usernames := make([]string, 0, len(m.accounts))
+	for username := range m.accounts {
+		usernames = append(usernames, username)
+	}
+	return usernames, nil
+}
+
+func (m *MemoryStorage) HasAccount(username string) (bool, error) {
+	m.mu.RLock()
+	_, ok := m.accounts[username]
+	m.mu.RUnlock()
+	return ok, nil
+}
+
+func (m *MemoryStorage) SaveAccount(dump *AccountDumpDB) error {
+	dat, err := bson.Marshal(dump)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if oldName, ok := m.accountIds[dump.Id]; ok && oldName != dump.Username {
+		delete(m.accounts, oldName)
+	}
+	m.accounts[dump.Username] = dat
+	m.accountIds[dump.Id] = dump.Username
+	return nil
+}
+
+func (m *MemoryStorage) SaveChar(accountId bson.ObjectId, slotIndex int, dump *CharDumpDB) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	username, ok := m.accountIds[accountId]
+	if !ok {
+		return ErrStorageNotFound
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(m.accounts[username], &doc); err != nil {
+		return err
+	}
+	accDump, err := DecodeAccountDoc(doc)
+	if err != nil {
+		return err
+	}
+	for len(accDump.Chars) <= slotIndex {
+		accDump.Chars = append(accDump.Chars, nil)
+	}
+	accDump.Chars[slotIndex] = dump
+	dat, err := bson.Marshal(accDump)
+	if err != nil {
+		return err
+	}
+	m.accounts[username] = dat
+	return nil
+}
+
+func (m *MemoryStorage) HasCharName(name string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, dat := range m.accounts {
+		accDump := &struct {
+			Chars []struct {
+				Name string `bson:"name"`
+			} `bson:"chars"`
+		}{}
+		if err := bson.Unmarshal(dat, accDump); err != nil {
+			return false, err
+		}
+		for _, c := range accDump.Chars {
+			if c.Name == name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStorage) ImportItems(items []interface{}) error {
+	newItems := make(map[int][]byte, len(items))
+	for _, item := range items {
+		dat, err := bson.Marshal(item)
+		if err != nil {
+			return err
+		}
+		key := &struct {
+			Item struct {
+				BaseId int `bson:"baseId"`
+			} `bson:"item"`
+		}{}
+		if err := bson.Unmarshal(dat, key); err != nil {
+			return err
+		}
+		newItems[key.Item.BaseId] = dat
+	}
+	m.mu.Lock()
+	m.items = newItems
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryStorage) SaveCharSnapshot(snap *CharSnapshot, keep int) error {
+	dat, err := bson.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snaps := append(m.charSnapshots[snap.CharId], dat)
+	if keep > 0 && len(snaps) > keep {
+		snaps = snaps[len(snaps)-keep:]
+	}
+	m.charSnapshots[snap.CharId] = snaps
+	return nil
+}
+
+func (m *MemoryStorage) FindCharSnapshots(charId bson.ObjectId) ([]*CharSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dats := m.charSnapshots[charId]
+	snaps := make([]*CharSnapshot, 0, len(dats))
+	for i := len(dats) - 1; i >= 0; i-- {
+		doc := bson.M{}
+		if err := bson.Unmarshal(dats[i], &doc); err != nil {
+			return nil, err
+		}
+		snap, err := DecodeCharSnapshotDoc(doc)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+func (m *MemoryStorage) FindCharSnapshot(id bson.ObjectId) (*CharSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, dats := range m.charSnapshots {
+		for _, dat := range dats {
+			doc := bson.M{}
+			if err := bson.Unmarshal(dat, &doc); err != nil {
+				return nil, err
+			}
+			if doc["_id"] == id {
+				return DecodeCharSnapshotDoc(doc)
+			}
+		}
+	}
+	return nil, ErrStorageNotFound
+}
+
+func (m *MemoryStorage) AppendLedger(entries []*LedgerEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range entries {
+		entry := *e
+		m.ledger = append(m.ledger, &entry)
+	}
+	return nil
+}
+
+func (m *MemoryStorage) FindLedger(q *LedgerQuery) ([]*LedgerEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := []*LedgerEntry{}
+	for i := len(m.ledger) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(entries) >= q.Limit {
+			break
+		}
+		if q.Match(m.ledger[i]) {
+			entry := *m.ledger[i]
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryStorage) UpdateAccountIndex() {}
+
+func (m *MemoryStorage) Clone() Storage {
+	return m
+}
+
+func (m *MemoryStorage) Close() {}
@@ -0,0 +1,346 @@
+package dao
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"time"
+)
+
+var (
+	boltAccountsBucket   = []byte("accounts")
+	boltAccountIdsBucket = []byte("accountIds")
+	boltItemsBucket      = []byte("items")
+	// sub bucket per char, keyed by snapshot id.
+	boltCharSnapshotsBucket = []byte("charSnapshots")
+	// snapshot id to char id
+	boltCharSnapshotIdsBucket = []byte("charSnapshotIds")
+	// keyed by entry id, sorted by time.
+	boltLedgerBucket = []byte("ledger")
+)
+
+// BoltStorage is a single file Storage for small deployments,
+// values are bson encoded like the mongo one.
+type BoltStorage struct {
+	db *bolt.DB
+	// only the opened one close the file, clones share it.
+	owner bool
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		buckets := [][]byte{
+			boltAccountsBucket,
+			boltAccountIdsBucket,
+			boltItemsBucket,
+			boltCharSnapshotsBucket,
+			boltCharSnapshotIdsBucket,
+			boltLedgerBucket,
+		}
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db, owner: true}, nil
+}
+
+func (b *BoltStorage) FindAccount(username string) (*AccountDumpDB, error) {
+	doc, err := b.FindAccountDoc(username)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeAccountDoc(doc)
+}
+
+func (b *BoltStorage) FindAccountDoc(username string) (bson.M, error) {
+	doc := bson.M{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		dat := tx.Bucket(boltAccountsBucket).Get([]byte(username))
+		if dat == nil {
+			return ErrStorageNotFound
+		}
+		return bson.Unmarshal(dat, &doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (b *BoltStorage) AccountUsernames() ([]string, error) {
+	usernames := []string{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAccountsBucket).ForEach(func(k, v []byte) error {
+			usernames = append(usernames, string(k))
+			return nil
+		})
+	})
+	return usernames, err
+}
+
+func (b *BoltStorage) HasAccount(username string) (bool, error) {
+	found := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(boltAccountsBucket).Get([]byte(username)) != nil
+		return nil
+	})
+	return found, err
+}
+
+func (b *BoltStorage) SaveAccount(dump *AccountDumpDB) error {
+	dat, err := bson.Marshal(dump)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		accs := tx.Bucket(boltAccountsBucket)
+		ids := tx.Bucket(boltAccountIdsBucket)
+		id := []byte(dump.Id.Hex())
+		oldName := ids.Get(id)
+		if oldName != nil && string(oldName) != dump.Username {
+			if err := accs.Delete(oldName); err != nil {
+				return err
+			}
+		}
+		if err := accs.Put([]byte(dump.Username), dat); err != nil {
+			return err
+		}
+		return ids.Put(id, []byte(dump.Username))
+	})
+}
+
+func (b *BoltStorage) SaveChar(accountId bson.ObjectId, slotIndex int, dump *CharDumpDB) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		accs := tx.Bucket(boltAccountsBucket)
+		name := tx.Bucket(boltAccountIdsBucket).Get([]byte(accountId.Hex()))
+		if name == nil {
+			return ErrStorageNotFound
+		}
+		username := append([]byte{}, name...)
+		doc := bson.M{}
+		if err := bson.Unmarshal(accs.Get(username), &doc); err != nil {
+			return err
+		}
+		accDump, err := DecodeAccountDoc(doc)
+		if err != nil {
+			return err
+		}
+		for len(accDump.Chars) <= slotIndex {
+			accDump.Chars = append(accDump.Chars, nil)
+		}
+		accDump.Chars[slotIndex] = dump
+		dat, err := bson.Marshal(accDump)
+		if err != nil {
+			return err
+		}
+		return accs.Put(username, dat)
+	})
+}
+
+func (b *BoltStorage) HasCharName(name string) (bool, error) {
+	found := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAccountsBucket).ForEach(func(k, v []byte) error {
+			accDump := &struct {
+				Chars []struct {
+					Name string `bson:"name"`
+				} `bson:"chars"`
+			}{}
+			if err := bson.Unmarshal(v, accDump); err != nil {
+				return err
+			}
+			for _, c := range accDump.Chars {
+				if c.Name == name {
+					found = true
+				}
+			}
+			return nil
+		})
+	})
+	return found, err
+}
+
+func (b *BoltStorage) ImportItems(items []interface{}) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltItemsBucket); err != nil &&
+			err != bolt.ErrBucketNotFound {
+			return err
+		}
+		bucket, err := tx.CreateBucket(boltItemsBucket)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			dat, err := bson.Marshal(item)
+			if err != nil {
+				return err
+			}
+			key := &struct {
+				Item struct {
+					BaseId int `bson:"baseId"`
+				} `bson:"item"`
+			}{}
+			if err := bson.Unmarshal(dat, key); err != nil {
+				return err
+			}
+			err = bucket.Put([]byte(strconv.Itoa(key.Item.BaseId)), dat)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStorage) SaveCharSnapshot(snap *CharSnapshot, keep int) error {
+	dat, err := bson.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		charId := []byte(snap.CharId.Hex())
+		ids := tx.Bucket(boltCharSnapshotIdsBucket)
+		snaps, err := tx.Bucket(boltCharSnapshotsBucket).CreateBucketIfNotExists(charId)
+		if err != nil {
+			return err
+		}
+		// object id hex sorted by created time.
+		id := []byte(snap.Id.Hex())
+		if err := snaps.Put(id, dat); err != nil {
+			return err
+		}
+		if err := ids.Put(id, charId); err != nil {
+			return err
+		}
+		if keep <= 0 {
+			return nil
+		}
+		// Stats of bucket may not count what just put in this tx.
+		keys := [][]byte{}
+		cursor := snaps.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for i := 0; i < len(keys)-keep; i++ {
+			if err := ids.Delete(keys[i]); err != nil {
+				return err
+			}
+			if err := snaps.Delete(keys[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStorage) FindCharSnapshots(charId bson.ObjectId) ([]*CharSnapshot, error) {
+	snaps := []*CharSnapshot{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltCharSnapshotsBucket).Bucket([]byte(charId.Hex()))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			doc := bson.M{}
+			if err := bson.Unmarshal(v, &doc); err != nil {
+				return err
+			}
+			snap, err := DecodeCharSnapshotDoc(doc)
+			if err != nil {
+				return err
+			}
+			snaps = append(snaps, snap)
+		}
+		return nil
+	})
+	return snaps, err
+}
+
+func (b *BoltStorage) FindCharSnapshot(id bson.ObjectId) (*CharSnapshot, error) {
+	doc := bson.M{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		key := []byte(id.Hex())
+		charId := tx.Bucket(boltCharSnapshotIdsBucket).Get(key)
+		if charId == nil {
+			return ErrStorageNotFound
+		}
+		bucket := tx.Bucket(boltCharSnapshotsBucket).Bucket(charId)
+		if bucket == nil {
+			return ErrStorageNotFound
+		}
+		dat := bucket.Get(key)
+		if dat == nil {
+			return ErrStorageNotFound
+		}
+		return bson.Unmarshal(dat, &doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return DecodeCharSnapshotDoc(doc)
+}
+
+func (b *BoltStorage) AppendLedger(entries []*LedgerEntry) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltLedgerBucket)
+		for _, e := range entries {
+			dat, err := bson.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(e.Id.Hex()), dat); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStorage) FindLedger(q *LedgerQuery) ([]*LedgerEntry, error) {
+	entries := []*LedgerEntry{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltLedgerBucket).Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			if q.Limit > 0 && len(entries) >= q.Limit {
+				break
+			}
+			e := &LedgerEntry{}
+			if err := bson.Unmarshal(v, e); err != nil {
+				return err
+			}
+			if !q.Since.IsZero() && e.Time.Before(q.Since) {
+				break
+			}
+			if q.Match(e) {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// accounts bucket keyed by username already.
+func (b *BoltStorage) UpdateAccountIndex() {}
+
+func (b *BoltStorage) Clone() Storage {
+	return &BoltStorage{db: b.db, owner: false}
+}
+
+func (b *BoltStorage) Close() {
+	if b.owner {
+		b.db.Close()
+	}
+}
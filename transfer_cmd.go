@@ -0,0 +1,122 @@
+package dao
+
+import (
+	"flag"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+)
+
+// ExportMain runs "dao export", writes one account as json.
+func ExportMain(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	configDir, backend := cmdFlags(fs)
+	username := fs.String("username", "", "Account to export.")
+	out := fs.String("out", "", "Output file, stdout if empty.")
+	withPassword := fs.Bool("withPassword", false, "Keep password hash, leave it out for bug reports.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *username == "" {
+		fmt.Fprintln(os.Stderr, "export: -username required")
+		return 2
+	}
+	_, db, err := cmdStorage(*configDir, *backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	exp, err := ExportAccount(db, *username, *withPassword)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export:", *username, err)
+		return 1
+	}
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := exp.Write(w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// ImportMain runs "dao import", account should be offline.
+func ImportMain(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	configDir, backend := cmdFlags(fs)
+	in := fs.String("in", "", "Exported file, stdin if empty.")
+	username := fs.String("username", "", "Import as username, exported one if empty.")
+	replace := fs.Bool("replace", false, "Replace existing account, its chars snapshoted first.")
+	remapIds := fs.Bool("remapIds", false, "New char and item ids even for same username.")
+	suffix := fs.String("charNameSuffix", "", "Appended to every char name.")
+	password := fs.String("password", "", "New password, required if exported without one.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	r := os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	exp, err := ReadAccountExport(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	configs, db, err := cmdStorage(*configDir, *backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	items, err := ReadDefaultJsonDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	catalog, err := NewItemCatalog(items, configs.ItemConfigs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	opts := &ImportAccountOptions{
+		Username:       *username,
+		Replace:        *replace,
+		RemapIds:       *remapIds,
+		CharNameSuffix: *suffix,
+		SnapshotKeep:   configs.StorageConfigs.Snapshot.Keep,
+	}
+	if *password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(*password), 10)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		opts.Password = string(hashed)
+	}
+	result, err := ImportAccount(db, catalog, exp, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	db.UpdateAccountIndex()
+	fmt.Printf("imported account %s, chars: %s, replaced: %v, remapped ids: %v\n",
+		result.Username, strings.Join(result.Chars, ", "),
+		result.Replaced, result.Remapped)
+	return 0
+}
@@ -0,0 +1,196 @@
+package dao
+
+const maxViewSnapshotHistory = 32
+
+// while nothing acked, a full snapshot is resent at most once per these ticks,
+// ticks between get delta against the last full one.
+const viewSnapshotFullResendTicks = 30
+
+type ViewSnapshotEntity struct {
+	X     float32
+	Y     float32
+	VX    float32
+	VY    float32
+	Angle float32
+	Hp    int
+	MaxHp int
+	Mp    int
+	MaxMp int
+}
+
+type ViewSnapshot struct {
+	seq       int
+	sceneName string
+	entities  map[int]*ViewSnapshotEntity
+}
+
+type ViewSnapshotEntityClient struct {
+	Id    int      `json:"id"`
+	X     *float32 `json:"x,omitempty"`
+	Y     *float32 `json:"y,omitempty"`
+	VX    *float32 `json:"vx,omitempty"`
+	VY    *float32 `json:"vy,omitempty"`
+	Angle *float32 `json:"angle,omitempty"`
+	Hp    *int     `json:"hp,omitempty"`
+	MaxHp *int     `json:"maxHp,omitempty"`
+	Mp    *int     `json:"mp,omitempty"`
+	MaxMp *int     `json:"maxMp,omitempty"`
+}
+
+type ViewSnapshotDeltaClient struct {
+	Seq       int                         `json:"seq"`
+	BaseSeq   int                         `json:"baseSeq"`
+	SceneName string                      `json:"sceneName"`
+	Entities  []*ViewSnapshotEntityClient `json:"entities,omitempty"`
+	Removed   []int                       `json:"removed,omitempty"`
+}
+
+// ViewSnapshotState keep what snapshots sent to one client,
+// delta diff with last acked one, or last full one before any ack.
+type ViewSnapshotState struct {
+	seq      int
+	ackedSeq int
+	history  map[int]*ViewSnapshot
+	// last full snapshot sent and ticks since it.
+	fullSeq   int
+	fullTicks int
+}
+
+func NewViewSnapshotState() *ViewSnapshotState {
+	return &ViewSnapshotState{
+		history: make(map[int]*ViewSnapshot, maxViewSnapshotHistory),
+	}
+}
+
+func NewViewSnapshotEntity(b Bioer) *ViewSnapshotEntity {
+	body := b.CpBody()
+	pos := body.Position()
+	vel := body.Velocity()
+	attrs := b.BioClientAttributes()
+	return &ViewSnapshotEntity{
+		X:     float32(pos.X),
+		Y:     float32(pos.Y),
+		VX:    float32(vel.X),
+		VY:    float32(vel.Y),
+		Angle: float32(body.Angle()),
+		Hp:    attrs.Hp,
+		MaxHp: attrs.MaxHp,
+		Mp:    attrs.Mp,
+		MaxMp: attrs.MaxMp,
+	}
+}
+
+// base nil means client known nothing about it, send all fields.
+func (e *ViewSnapshotEntity) Diff(id int, base *ViewSnapshotEntity) *ViewSnapshotEntityClient {
+	full := base == nil
+	if full {
+		base = &ViewSnapshotEntity{}
+	}
+	client := &ViewSnapshotEntityClient{Id: id}
+	changed := false
+	diffFloat := func(v float32, baseV float32) *float32 {
+		if !full && v == baseV {
+			return nil
+		}
+		changed = true
+		return &v
+	}
+	diffInt := func(v int, baseV int) *int {
+		if !full && v == baseV {
+			return nil
+		}
+		changed = true
+		return &v
+	}
+	client.X = diffFloat(e.X, base.X)
+	client.Y = diffFloat(e.Y, base.Y)
+	client.VX = diffFloat(e.VX, base.VX)
+	client.VY = diffFloat(e.VY, base.VY)
+	client.Angle = diffFloat(e.Angle, base.Angle)
+	client.Hp = diffInt(e.Hp, base.Hp)
+	client.MaxHp = diffInt(e.MaxHp, base.MaxHp)
+	client.Mp = diffInt(e.Mp, base.Mp)
+	client.MaxMp = diffInt(e.MaxMp, base.MaxMp)
+	if !changed {
+		return nil
+	}
+	return client
+}
+
+func (vs *ViewSnapshotState) Baseline() *ViewSnapshot {
+	if vs.ackedSeq == 0 {
+		return nil
+	}
+	return vs.history[vs.ackedSeq]
+}
+
+// return nil when nothing changed since acked snapshot.
+func (vs *ViewSnapshotState) Next(sceneName string, entities map[int]*ViewSnapshotEntity) *ViewSnapshotDeltaClient {
+	vs.fullTicks += 1
+	base := vs.Baseline()
+	if base != nil && base.sceneName != sceneName {
+		base = nil
+	}
+	if base == nil && vs.fullTicks < viewSnapshotFullResendTicks {
+		// unacked client, don't flood it with full snapshots.
+		full := vs.history[vs.fullSeq]
+		if full != nil && full.sceneName == sceneName {
+			base = full
+		}
+	}
+	delta := &ViewSnapshotDeltaClient{
+		SceneName: sceneName,
+		Entities:  make([]*ViewSnapshotEntityClient, 0),
+	}
+	var baseEntities map[int]*ViewSnapshotEntity
+	if base != nil {
+		delta.BaseSeq = base.seq
+		baseEntities = base.entities
+	}
+	for id, e := range entities {
+		eClient := e.Diff(id, baseEntities[id])
+		if eClient != nil {
+			delta.Entities = append(delta.Entities, eClient)
+		}
+	}
+	for id, _ := range baseEntities {
+		if _, ok := entities[id]; !ok {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+	if base != nil && len(delta.Entities) == 0 && len(delta.Removed) == 0 {
+		return nil
+	}
+	vs.seq += 1
+	delta.Seq = vs.seq
+	if base == nil {
+		vs.fullSeq = vs.seq
+		vs.fullTicks = 0
+	}
+	vs.history[vs.seq] = &ViewSnapshot{
+		seq:       vs.seq,
+		sceneName: sceneName,
+		entities:  entities,
+	}
+	delete(vs.history, vs.seq-maxViewSnapshotHistory)
+	return delta
+}
+
+func (vs *ViewSnapshotState) Ack(seq int) {
+	if seq <= vs.ackedSeq || seq > vs.seq {
+		return
+	}
+	for s, _ := range vs.history {
+		if s < seq {
+			delete(vs.history, s)
+		}
+	}
+	vs.ackedSeq = seq
+}
+
+func (vs *ViewSnapshotState) Reset() {
+	vs.ackedSeq = 0
+	vs.fullSeq = 0
+	vs.fullTicks = 0
+	vs.history = make(map[int]*ViewSnapshot, maxViewSnapshotHistory)
+}
@@ -4,13 +4,13 @@ import (
 	"errors"
 	"github.com/xuhaojun/emission-otto"
 	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"log"
 	"os"
 	"reflect"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,9 +27,14 @@ type World struct {
 	scenes   map[string]*Scene
 	timers   map[*WorldTimer]*WorldTimer
 	partys   map[string]*Party
-	db       *DaoDB
+	db       Storage
+	persist  *PersistQueue
+	ledger   *Ledger
 	configs  *DaoConfigs
 	logger   *log.Logger
+	// *ItemCatalog
+	itemCatalog    atomic.Value
+	itemDupScanner *ItemDupScanner
 	//
 	accountLoginBySessionMap map[string]string
 	addAccountLoginBySession chan AccountLoginBySession
@@ -37,6 +42,8 @@ type World struct {
 	// RegisterAccount chan *WorldRegisterAccount
 	// LoginAccount    chan *WorldLoginAccount
 	LogoutAccount chan *Account
+	DetachAccount chan *wsConn
+	accountFound  chan *accountFound
 	//
 	SceneObjecterChangeScene chan *ChangeScene
 	//
@@ -47,15 +54,17 @@ type World struct {
 	// AccountLoginChar  chan *AccountLoginChar
 	// AccountCreateChar chan *AccountCreateChar
 	//
-	ParseClientCall chan WorldParseClientCall
+	ParseClientCall    chan WorldParseClientCall
+	clientCallRegistry *ClientCallRegistry
 	//
 	interpreter      *WorldInterpreter
 	InterpreterREPL  chan string
 	InterpreterTimer chan *OttoTimer
 	worldTimer       chan *WorldTimer
 	//
-	delta    float32
-	timeStep time.Duration
+	delta     float32
+	timeStep  time.Duration
+	tickStats *WorldTickStats
 	//
 	job  chan func()
 	Quit chan struct{}
@@ -69,12 +78,6 @@ type ChangeScene struct {
 	Scene         *Scene
 }
 
-type WorldClientCall interface {
-	RegisterAccount(username string, password string, email string, sock *wsConn)
-	LoginAccount(username string, password string, sock *wsConn)
-	LoginAccountBySessionToken(username string, token string, sock *wsConn)
-}
-
 type WorldTimer struct {
 	timer    *time.Timer
 	duration time.Duration
@@ -82,7 +85,7 @@ type WorldTimer struct {
 	call     reflect.Value
 }
 
-func NewWorld(db *DaoDB, configs *DaoConfigs) (*World, error) {
+func NewWorld(db Storage, configs *DaoConfigs) (*World, error) {
 	name := configs.WorldConfigs.Name
 	numCPU := runtime.NumCPU()
 	w := &World{
@@ -93,8 +96,11 @@ func NewWorld(db *DaoDB, configs *DaoConfigs) (*World, error) {
 		configs:                  NewDaoConfigs("./"),
 		logger:                   log.New(os.Stdout, "[dao-"+name+"] ", 0),
 		LogoutAccount:            make(chan *Account, numCPU),
+		DetachAccount:            make(chan *wsConn, numCPU),
+		accountFound:             make(chan *accountFound, numCPU),
 		SceneObjecterChangeScene: make(chan *ChangeScene, numCPU),
 		ParseClientCall:          make(chan WorldParseClientCall, numCPU),
+		clientCallRegistry:       NewDefaultClientCallRegistry(),
 		InterpreterREPL:          make(chan string, numCPU),
 		InterpreterTimer:         make(chan *OttoTimer, numCPU),
 		worldTimer:               make(chan *WorldTimer, numCPU),
@@ -116,18 +122,28 @@ func NewWorld(db *DaoDB, configs *DaoConfigs) (*World, error) {
 	if configs != nil {
 		w.configs = configs
 	}
+	w.tickStats = NewWorldTickStats(w.timeStep)
+	w.persist = NewPersistQueue(db, w.configs.StorageConfigs.Persist, w.logger)
+	w.ledger = NewLedger(db, w.configs.StorageConfigs.Ledger, w.logger)
+	w.itemDupScanner = NewItemDupScanner(db, w.persist, w.logger)
+	items, err := ReadDefaultJsonDB()
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := NewItemCatalog(items, w.configs.ItemConfigs)
+	if err != nil {
+		return nil, err
+	}
+	w.itemCatalog.Store(catalog)
 	// scenes
-	daoCity := NewWallScene(w, "daoCity", 2000, 2000)
-	w.scenes["daoCity"] = daoCity
-	//
-	daoField01 := NewWallScene(w, "daoField01", 6000, 6000)
-	daoField01.defaultGroundTextureName = "dirt"
-	w.scenes["daoField01"] = daoField01
-	daoField01.enableNoUpdateOnZeroChar = true
+	err = w.loadScenes()
+	if err != nil {
+		return nil, err
+	}
 	// interpreter
 	w.interpreter = NewWorldInterpreter(w)
 	w.Emitter = emission.NewEmitterOtto(w.interpreter.vm)
-	err := w.interpreter.LoadScripts()
+	err = w.interpreter.LoadScripts()
 	if err != nil {
 		return nil, err
 	}
@@ -150,13 +166,78 @@ func (w *World) Name() string {
 	return w.name
 }
 
+func (w *World) loadJsonDB() (*ItemCatalog, *ItemCatalogDiff, error) {
+	items, err := ReadDefaultJsonDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	catalog, err := NewItemCatalog(items, w.configs.ItemConfigs)
+	if err != nil {
+		return nil, nil, err
+	}
+	diff := DiffItemCatalog(w.ItemCatalog(), catalog)
+	diff.CheckInUse(w.itemBaseIdsInUse())
+	return catalog, diff, nil
+}
+
+// itemBaseIdsInUse maps baseIds npc shops and mob drops of scenes
+// use to who uses it.
+func (w *World) itemBaseIdsInUse() map[int]string {
+	inUse := make(map[int]string)
+	for _, scene := range w.scenes {
+		for _, sb := range scene.sceneObjects {
+			switch user := sb.(type) {
+			case *Npc:
+				if user.shop == nil {
+					continue
+				}
+				for _, id := range user.shop.itemBaseIds {
+					inUse[id] = "shop " + user.shop.name
+				}
+			case *Mob:
+				for _, id := range user.dropItemBaseIds {
+					inUse[id] = "drops of mob " + user.name
+				}
+			}
+		}
+	}
+	return inUse
+}
+
+// PreviewJsonDB validates db/item_db.json and
+// returns what ReloadJsonDB would change.
+func (w *World) PreviewJsonDB() *ItemCatalogDiff {
+	_, diff, err := w.loadJsonDB()
+	if err != nil {
+		w.logger.Println("Error PreviewJsonDB", err)
+		return nil
+	}
+	return diff
+}
+
+// ReloadJsonDB keeps current items if new ones are invalid.
 func (w *World) ReloadJsonDB() (err error) {
 	w.logger.Println("Reloading JsonDB")
-	err = w.db.ImportDefaultJsonDB()
+	catalog, diff, err := w.loadJsonDB()
+	if err != nil {
+		w.logger.Println("Error ReloadJsonDB", err)
+		return
+	}
+	w.logger.Println("JsonDB items:", diff)
+	if len(diff.RemovedInUse) > 0 {
+		inUse := w.itemBaseIdsInUse()
+		for _, id := range diff.RemovedInUse {
+			w.logger.Println("Error ReloadJsonDB baseId", id, "still used by", inUse[id])
+		}
+		err = errors.New("removed items still in use")
+		return
+	}
+	err = w.db.ImportItems(catalog.raw)
 	if err != nil {
-		w.logger.Println("Error ReloadJsonDB")
+		w.logger.Println("Error ReloadJsonDB", err)
 		return
 	}
+	w.itemCatalog.Store(catalog)
 	w.cache = NewCache()
 	for _, acc := range w.accounts {
 		char := acc.usingChar
@@ -171,7 +252,15 @@ func (w *World) ReloadJsonDB() (err error) {
 
 func (w *World) ReloadDaoConfigs() (err error) {
 	w.logger.Println("Reloading DaoConfigs")
+	firstScene := *w.configs.CharConfigs.FirstScene
 	w.configs.ReloadConfigFiles()
+	// new chars and ones lost their scenes login there.
+	newFirst := w.configs.CharConfigs.FirstScene
+	if newFirst == nil || w.scenes[newFirst.Name] == nil {
+		w.configs.CharConfigs.FirstScene = &firstScene
+		err = errors.New("first scene not defined, keep " + firstScene.Name)
+		w.logger.Println("Error ReloadDaoConfigs", err)
+	}
 	w.configs.SceneConfigs.SetScenes(w.scenes)
 	w.logger.Println("Reloaded DaoConfigs!")
 	return
@@ -185,6 +274,7 @@ func (w *World) ReloadScripts() {
 	for _, scene := range w.scenes {
 		scene.RemoveAllMober()
 		scene.RemoveAllNpcer()
+		scene.placeDefNpcs()
 	}
 	w.interpreter.LoadScripts()
 	w.Emit("worldLoadScenes", w, w.scenes)
@@ -193,21 +283,24 @@ func (w *World) ReloadScripts() {
 
 func (w *World) ReloadAll() {
 	w.ReloadJsonDB()
+	w.ReloadScenes()
 	w.ReloadDaoConfigs()
 	w.ReloadScripts()
 }
 
-func (w *World) WorldClientCall() WorldClientCall {
-	return w
-}
-
 func (w *World) Run() {
-	err := w.db.ImportDefaultJsonDB()
+	err := w.db.ImportItems(w.ItemCatalog().raw)
 	if err != nil {
 		panic(err)
 	}
-	defer w.db.session.Close()
+	defer w.db.Close()
+	w.persist.Start()
+	go w.ledger.Run()
 	go w.interpreter.Run()
+	if dupScan := w.configs.ItemConfigs.DupScan; dupScan.Enable {
+		w.SetInterval(w.ScanItemDuplicates,
+			time.Duration(dupScan.IntervalSeconds)*time.Second)
+	}
 	physicC := time.Tick(w.timeStep)
 	sceneWg := &sync.WaitGroup{}
 	for i := 0; i < runtime.NumCPU(); i++ {
@@ -216,11 +309,19 @@ func (w *World) Run() {
 	for {
 		select {
 		case <-physicC:
+			tickStart := time.Now()
 			sceneWg.Add(len(w.scenes))
 			for _, scene := range w.scenes {
 				w.sceneUpdateJob <- scene
 			}
 			sceneWg.Wait()
+			for _, scene := range w.scenes {
+				scene.runPortals()
+			}
+			w.tickStats.Add(time.Since(tickStart))
+			if w.server != nil {
+				w.server.wsHub.FlushBatches()
+			}
 		case params := <-w.ParseClientCall:
 			w.DoParseClientCall(params.ClientCall, params.Conn)
 		case expr := <-w.InterpreterREPL:
@@ -231,6 +332,10 @@ func (w *World) Run() {
 			w.TimerEval(timer)
 		case acc := <-w.LogoutAccount:
 			w.DoLogoutAccount(acc)
+		case conn := <-w.DetachAccount:
+			w.DoDetachAccount(conn)
+		case found := <-w.accountFound:
+			found.done(found.dump, found.err)
 		case params := <-w.addAccountLoginBySession:
 			username := params.Username
 			sessionToken := params.SessionToken
@@ -259,6 +364,8 @@ func (w *World) Run() {
 				}(acc)
 			}
 			wg.Wait()
+			w.persist.Close()
+			w.ledger.Close()
 			w.Quit <- struct{}{}
 			return
 		}
@@ -275,12 +382,10 @@ func (w *World) sceneUpdateWorker(wg *sync.WaitGroup) {
 // TODO
 // should check username and password is right format!
 func (w *World) registerAccount(username string, password string, email string, sock *wsConn) {
-	db := w.db
-	queryAcc := bson.M{"username": username}
-	err := db.accounts.Find(queryAcc).Select(bson.M{"_id": 1}).One(&struct{}{})
-	if err != nil && err != mgo.ErrNotFound {
+	found, err := w.db.HasAccount(username)
+	if err != nil {
 		panic(err)
-	} else if err != mgo.ErrNotFound {
+	} else if found {
 		clientErr := []interface{}{"duplicated account!"}
 		clientCall := &ClientCall{
 			Receiver: "world",
@@ -288,7 +393,7 @@ func (w *World) registerAccount(username string, password string, email string,
 			Params:   clientErr,
 		}
 		sock.SendClientCall(clientCall)
-	} else if err == mgo.ErrNotFound {
+	} else {
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
 		if err != nil {
 			panic(err)
@@ -298,8 +403,11 @@ func (w *World) registerAccount(username string, password string, email string,
 		acc.world = w
 		acc.maxChars = w.configs.AccountConfigs.MaxChars
 		acc.Save()
-		d2 := w.db.CloneSession()
-		go d2.UpdateAccountIndex()
+		d2 := w.db.Clone()
+		go func() {
+			d2.UpdateAccountIndex()
+			d2.Close()
+		}()
 		clientParams := []interface{}{"success register a new account!"}
 		clientCall := &ClientCall{
 			Receiver: "world",
@@ -324,69 +432,29 @@ type WorldParseClientCall struct {
 }
 
 func (w *World) DoParseClientCall(clientCall *ClientCall, conn *wsConn) {
-	acc := conn.account
-	switch clientCall.Receiver {
-	case "World":
-		if acc != nil {
-			return
-		}
-		v := w.WorldClientCall()
-		var f reflect.Value
-		f, isFound := w.cache.WorldClientCallMethods[clientCall.Method]
-		if !isFound {
-			f = reflect.ValueOf(v).MethodByName(clientCall.Method)
-			if f.IsValid() {
-				w.cache.WorldClientCallMethods[clientCall.Method] = f
-			}
-		}
-		if !f.IsValid() {
-			return
-		}
-		if clientCall.Method == "LoginAccount" ||
-			clientCall.Method == "LoginAccountBySessionToken" ||
-			clientCall.Method == "RegisterAccount" {
-			clientCall.Params = append(clientCall.Params, conn)
-		}
-		in, err := clientCall.CastJSON(f)
-		if err != nil {
-			return
-		}
-		f.Call(in)
-	case "Account":
-		if acc == nil {
-			return
-		}
-		v := acc.AccountClientCall()
-		f := reflect.ValueOf(v).MethodByName(clientCall.Method)
-		if f.IsValid() == false {
-			return
-		}
-		in, err := clientCall.CastJSON(f)
-		if err != nil {
-			return
-		}
-		f.Call(in)
-	case "Char":
-		if acc == nil {
-			return
-		}
-		char := acc.UsingChar()
-		if char == nil {
-			return
-		}
-		v := char.CharClientCall()
-		f := reflect.ValueOf(v).MethodByName(clientCall.Method)
-		if f.IsValid() == false {
-			return
-		}
-		in, err := clientCall.CastJSON(f)
-		if err != nil {
-			return
-		}
-		f.Call(in)
-	default:
-		return
+	err := w.dispatchClientCall(clientCall, conn)
+	if err != nil && clientCall.Id != 0 {
+		conn.SendClientCall(err.ClientCall())
+	}
+}
+
+func (w *World) dispatchClientCall(clientCall *ClientCall, conn *wsConn) *ClientCallError {
+	isHello := clientCall.Receiver == "World" && clientCall.Method == "Hello"
+	if conn.hello == nil && !isHello &&
+		w.configs.ServerConfigs.RequireHello {
+		w.RefuseStaleClient(conn, "")
+		return nil
+	} else if conn.hello != nil && isHello {
+		return NewClientCallError(clientCall,
+			ClientCallErrInvalidParams, "hello already received")
 	}
+	ctx := &ClientCallContext{
+		World:   w,
+		Conn:    conn,
+		Account: conn.account,
+		Call:    clientCall,
+	}
+	return w.clientCallRegistry.Dispatch(ctx)
 }
 
 func (w *World) RequestParseClientCall(c *ClientCall, conn *wsConn) {
@@ -408,6 +476,35 @@ func (w *World) DoLogoutAccount(acc *Account) {
 	}
 }
 
+// socket of acc closed, keep it in world for resume grace.
+func (w *World) DoDetachAccount(conn *wsConn) {
+	acc := conn.account
+	if acc == nil || acc.sock != conn || w.accounts[acc.username] != acc {
+		return
+	}
+	grace := w.configs.AccountConfigs.ResumeGraceSeconds
+	if grace <= 0 || acc.resumeToken == "" || conn.kicked {
+		w.DoLogoutAccount(acc)
+		return
+	}
+	acc.Detach(time.Duration(grace) * time.Second)
+}
+
+func (w *World) ResumeSession(username string, token string, sock *wsConn) {
+	acc, ok := w.accounts[username]
+	if !ok || !acc.CheckResumeToken(token) {
+		clientCall := &ClientCall{
+			Receiver: "world",
+			Method:   "handleErrorResumeSession",
+			Params:   []interface{}{"session expired"},
+		}
+		sock.SendClientCall(clientCall)
+		return
+	}
+	acc.Resume(sock)
+	w.logger.Println("Account:", acc.username, "resumed.")
+}
+
 func (w *World) KickAccountByUsername(username string) {
 	acc, ok := w.accounts[username]
 	if ok {
@@ -466,94 +563,148 @@ func (w *World) TalkWorld(name string, content string) {
 }
 
 func (w *World) LoginAccountBySessionToken(username string, token string, sock *wsConn) {
-	_, isOnlineAccount := w.accounts[username]
+	onlineAcc, isOnlineAccount := w.accounts[username]
 	realToken, found := w.accountLoginBySessionMap[username]
-	if isOnlineAccount || !found || realToken != token {
-		clientErr := []interface{}{"wrong username or password"}
-		clientCall := &ClientCall{
-			Receiver: "world",
-			Method:   "handleErrorLoginAccount",
-			Params:   clientErr,
-		}
-		sock.SendClientCall(clientCall)
+	// detached one will be kicked like LoginAccount does.
+	if (isOnlineAccount && !onlineAcc.detached) || !found || realToken != token {
+		w.sendErrorLoginAccount(sock)
 		return
 	}
 	delete(w.accountLoginBySessionMap, username)
-	foundAcc := &AccountDumpDB{}
-	queryAcc := bson.M{"username": username}
-	err := w.db.accounts.Find(queryAcc).One(foundAcc)
-	if err != nil && err != mgo.ErrNotFound {
-		panic(err)
+	w.findAccountAsync(username, nil, func(foundAcc *AccountDumpDB, err error) {
+		if err == ErrStorageNotFound {
+			foundAcc = &AccountDumpDB{Username: username}
+		} else if err != nil {
+			panic(err)
+		}
+		if !w.canFinishLogin(sock) {
+			return
+		}
+		w.finishLogin(username, foundAcc, sock)
+	})
+}
+
+var errWrongPassword = errors.New("wrong password")
+
+type accountFound struct {
+	dump *AccountDumpDB
+	err  error
+	done func(dump *AccountDumpDB, err error)
+}
+
+// findAccount reads account after its pending saves written,
+// it blocks, only for admin tools.
+func (w *World) findAccount(username string) (*AccountDumpDB, error) {
+	w.persist.Wait(username)
+	return w.db.FindAccount(username)
+}
+
+// findAccountAsync waits pending saves and reads account off world loop,
+// then check runs there too if not nil, done runs on world loop.
+func (w *World) findAccountAsync(username string, check func(*AccountDumpDB) error, done func(*AccountDumpDB, error)) {
+	db := w.db.Clone()
+	go func() {
+		defer db.Close()
+		w.persist.Wait(username)
+		dump, err := db.FindAccount(username)
+		if err == nil && check != nil {
+			err = check(dump)
+		}
+		w.accountFound <- &accountFound{dump, err, done}
+	}()
+}
+
+// canFinishLogin is false if sock closed or logined
+// while its account was loading.
+func (w *World) canFinishLogin(sock *wsConn) bool {
+	if sock.account != nil {
+		return false
 	}
-	acc := foundAcc.Load(w)
-	w.accounts[acc.username] = acc
-	acc.Login(sock)
-	charClients := make([]interface{}, len(acc.chars))
-	for i, char := range acc.chars {
-		charClients[i] = char.CharClient()
+	select {
+	case <-sock.readQuit:
+		return false
+	default:
+		return true
 	}
+}
+
+func (w *World) sendErrorLoginAccount(sock *wsConn) {
+	clientErr := []interface{}{"wrong username or password"}
 	clientCall := &ClientCall{
 		Receiver: "world",
-		Method:   "handleSuccessLoginAcccount",
-		Params: []interface{}{
-			map[string]interface{}{
-				"username":    username,
-				"charConfigs": charClients,
-			},
-		},
+		Method:   "handleErrorLoginAccount",
+		Params:   clientErr,
 	}
 	sock.SendClientCall(clientCall)
+}
+
+func (w *World) doLoginAccount(foundAcc *AccountDumpDB, sock *wsConn) {
+	acc := foundAcc.Load(w)
+	w.accounts[acc.username] = acc
+	acc.Login(sock)
+	sock.SendClientCall(acc.ClientSuccessLoginAccount(false))
 	w.logger.Println("Account:", acc.username, "Logined.")
 }
 
 func (w *World) LoginAccount(username string, password string, sock *wsConn) {
-	_, isOnlineAccount := w.accounts[username]
-	if isOnlineAccount {
-		clientErr := []interface{}{"wrong username or password"}
-		clientCall := &ClientCall{
-			Receiver: "world",
-			Method:   "handleErrorLoginAccount",
-			Params:   clientErr,
-		}
-		sock.SendClientCall(clientCall)
+	onlineAcc, isOnlineAccount := w.accounts[username]
+	// detached one will be kicked after password checked.
+	if isOnlineAccount && !onlineAcc.detached {
+		w.sendErrorLoginAccount(sock)
 		return
 	}
-	foundAcc := &AccountDumpDB{}
-	queryAcc := bson.M{"username": username}
-	err := w.db.accounts.Find(queryAcc).One(foundAcc)
-	if err != nil && err != mgo.ErrNotFound {
-		panic(err)
+	// any compare error, bad stored hash too, is a failed login,
+	// only storage errors are fatal.
+	checkPassword := func(foundAcc *AccountDumpDB) error {
+		err := bcrypt.CompareHashAndPassword([]byte(foundAcc.Password), []byte(password))
+		if err != nil {
+			return errWrongPassword
+		}
+		return nil
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(foundAcc.Password), []byte(password))
-	if err == mgo.ErrNotFound || err != nil {
-		clientErr := []interface{}{"wrong username or password"}
-		clientCall := &ClientCall{
-			Receiver: "world",
-			Method:   "handleErrorLoginAccount",
-			Params:   clientErr,
+	w.findAccountAsync(username, checkPassword, func(foundAcc *AccountDumpDB, err error) {
+		if err != nil && err != ErrStorageNotFound && err != errWrongPassword {
+			panic(err)
 		}
-		sock.SendClientCall(clientCall)
+		if !w.canFinishLogin(sock) {
+			return
+		}
+		if err != nil {
+			w.sendErrorLoginAccount(sock)
+			return
+		}
+		w.finishLogin(username, foundAcc, sock)
+	})
+}
+
+// finishLogin logs out detached account of username first,
+// still online one fails the login.
+func (w *World) finishLogin(username string, foundAcc *AccountDumpDB, sock *wsConn) {
+	onlineAcc, isOnlineAccount := w.accounts[username]
+	if isOnlineAccount && !onlineAcc.detached {
+		w.sendErrorLoginAccount(sock)
 		return
 	}
 	delete(w.accountLoginBySessionMap, username)
-	acc := foundAcc.Load(w)
-	w.accounts[acc.username] = acc
-	acc.Login(sock)
-	charClients := make([]interface{}, len(acc.chars))
-	for i, char := range acc.chars {
-		charClients[i] = char.CharClient()
-	}
-	param := map[string]interface{}{
-		"username":    username,
-		"charConfigs": charClients,
-	}
-	clientCall := &ClientCall{
-		Receiver: "world",
-		Method:   "handleSuccessLoginAcccount",
-		Params:   []interface{}{param},
+	if !isOnlineAccount {
+		w.doLoginAccount(foundAcc, sock)
+		return
 	}
-	sock.SendClientCall(clientCall)
-	w.logger.Println("Account:", acc.username, "Logined.")
+	w.DoLogoutAccount(onlineAcc)
+	// reload, it just saved.
+	w.findAccountAsync(username, nil, func(foundAcc *AccountDumpDB, err error) {
+		if err != nil {
+			panic(err)
+		}
+		if !w.canFinishLogin(sock) {
+			return
+		}
+		if _, isOnlineAccount := w.accounts[username]; isOnlineAccount {
+			w.sendErrorLoginAccount(sock)
+			return
+		}
+		w.doLoginAccount(foundAcc, sock)
+	})
 }
 
 func (w *World) DaoConfigs() *DaoConfigs {
@@ -599,56 +750,23 @@ func (w *World) FindSceneByName(name string) *Scene {
 	return s
 }
 
+func (w *World) ItemCatalog() *ItemCatalog {
+	return w.itemCatalog.Load().(*ItemCatalog)
+}
+
 func (w *World) NewItemByBaseId(id int) (item Itemer, err error) {
 	if id <= 0 {
 		return nil, errors.New("out range")
 	}
-	var iType string
-	if id >= 1 && id <= 5000 {
-		iType = "equipment"
-	} else if id >= 5001 && id <= 10000 {
-		iType = "useSelfItem"
-	} else {
-		iType = "etcItem"
-	}
-	eqDB := NewEquipment().DB()
-	useDump := NewUseSelfItem().DumpDB()
-	etcDump := NewEtcItem().DumpDB()
-	queryItem := bson.M{"item.baseId": id}
-	switch iType {
-	case "equipment":
-		err = w.db.items.Find(queryItem).One(eqDB)
-	case "useSelfItem":
-		err = w.db.items.Find(queryItem).One(useDump)
-	case "etcItem":
-		err = w.db.items.Find(queryItem).One(etcDump)
-	}
-	if err == nil {
-	} else if err != nil && err != mgo.ErrNotFound {
-		return
-	}
-	if err == mgo.ErrNotFound {
+	catalog := w.ItemCatalog()
+	item, err = catalog.NewItem(id)
+	if err != nil {
 		return
 	}
-	switch iType {
-	case "equipment":
-		item = eqDB.DumpDB().Load()
-	case "useSelfItem":
-		config := w.DaoConfigs().ItemConfigs
-		useDump.MaxStackCount = config.UseSelfItemConfigs.MaxStackCount
-		item = useDump.Load()
-		onUse := w.ParseUseSelfFuncArrays(useDump.UseSelfFuncArrays, item)
-		item.(*UseSelfItem).onUse = onUse
-	case "etcItem":
-		config := w.DaoConfigs().ItemConfigs
-		etcDump.MaxStackCount = config.EtcItemConfigs.MaxStackCount
-		item = etcDump.Load()
-	}
-	if item.IconViewId() == 0 {
-		item.SetIconViewId(item.BaseId())
-	}
-	if item.BuyPrice() != 0 && item.SellPrice() == 0 {
-		item.SetSellPrice(int(float32(item.BuyPrice()) * 0.5))
+	item.SetInstanceId(bson.NewObjectId())
+	if uItem, ok := item.(*UseSelfItem); ok {
+		useCalls := catalog.UseSelfFuncArrays(id)
+		uItem.onUse = w.ParseUseSelfFuncArrays(useCalls, item)
 	}
 	return
 }
@@ -730,6 +848,18 @@ func (w *World) TimerEval(timer *WorldTimer) {
 	}
 }
 
+func (w *World) TickStats() *WorldTickStats {
+	return w.tickStats
+}
+
+func (w *World) PersistQueue() *PersistQueue {
+	return w.persist
+}
+
+func (w *World) Ledger() *Ledger {
+	return w.ledger
+}
+
 func (w *World) NewParty() *Party {
 	party := NewParty()
 	w.partys[party.uuid] = party
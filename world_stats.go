@@ -0,0 +1,92 @@
+package dao
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 10 seconds of 60fps ticks.
+const worldTickStatsWindow = 600
+
+type WorldTickStats struct {
+	mutex      sync.Mutex
+	budget     time.Duration
+	ticks      int64
+	total      time.Duration
+	last       time.Duration
+	overBudget int64
+	recent     []time.Duration
+	next       int
+}
+
+type WorldTickStatsClient struct {
+	Ticks       int64   `json:"ticks"`
+	BudgetMs    float64 `json:"budgetMs"`
+	TotalMs     float64 `json:"totalMs"`
+	LastMs      float64 `json:"lastMs"`
+	OverBudget  int64   `json:"overBudget"`
+	RecentAvgMs float64 `json:"recentAvgMs"`
+	RecentP99Ms float64 `json:"recentP99Ms"`
+	RecentMaxMs float64 `json:"recentMaxMs"`
+}
+
+func NewWorldTickStats(budget time.Duration) *WorldTickStats {
+	return &WorldTickStats{
+		budget: budget,
+		recent: make([]time.Duration, 0, worldTickStatsWindow),
+	}
+}
+
+func (ts *WorldTickStats) Add(d time.Duration) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.ticks += 1
+	ts.total += d
+	ts.last = d
+	if d > ts.budget {
+		ts.overBudget += 1
+	}
+	if len(ts.recent) < worldTickStatsWindow {
+		ts.recent = append(ts.recent, d)
+	} else {
+		ts.recent[ts.next] = d
+		ts.next = (ts.next + 1) % worldTickStatsWindow
+	}
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (ts *WorldTickStats) Client() *WorldTickStatsClient {
+	ts.mutex.Lock()
+	recent := make([]time.Duration, len(ts.recent))
+	copy(recent, ts.recent)
+	client := &WorldTickStatsClient{
+		Ticks:      ts.ticks,
+		BudgetMs:   durationMs(ts.budget),
+		TotalMs:    durationMs(ts.total),
+		LastMs:     durationMs(ts.last),
+		OverBudget: ts.overBudget,
+	}
+	ts.mutex.Unlock()
+	if len(recent) == 0 {
+		return client
+	}
+	sort.Sort(durationSlice(recent))
+	var sum time.Duration
+	for _, d := range recent {
+		sum += d
+	}
+	client.RecentAvgMs = durationMs(sum / time.Duration(len(recent)))
+	client.RecentP99Ms = durationMs(recent[(len(recent)-1)*99/100])
+	client.RecentMaxMs = durationMs(recent[len(recent)-1])
+	return client
+}
+
+type durationSlice []time.Duration
+
+func (p durationSlice) Len() int           { return len(p) }
+func (p durationSlice) Less(i, j int) bool { return p[i] < p[j] }
+func (p durationSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }